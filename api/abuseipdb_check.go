@@ -2,37 +2,99 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	abuseIpDbCheckerBaseUrl = "https://api.abuseipdb.com/api/v2/check"
+	AbuseIpDbDefaultBaseUrl       = "https://api.abuseipdb.com/api/v2"
+	AbuseIpDbDefaultTimeout       = 500 * time.Millisecond
+	AbuseIpDbDefaultMaxAgeInDays  = 90
+	AbuseIpDbDefaultMinConfidence = 75
+	AbuseIpDbMaxAgeInDaysLimit    = 365
+	abuseIpDbCheckCacheSize       = 10000
 )
 
+var (
+	ErrAbuseIpDbUnauthorized = errors.New("abuseipdb rejected the api key")
+	ErrAbuseIpDbRateLimited  = errors.New("abuseipdb rate limit exceeded")
+)
+
+// abuseIpDbStatusError maps a non-200 status of the AbuseIPDB API to an
+// error, telling a bad key and an exhausted rate limit apart.
+func abuseIpDbStatusError(status int) error {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: http status %d", ErrAbuseIpDbUnauthorized, status)
+	case http.StatusTooManyRequests:
+		return ErrAbuseIpDbRateLimited
+	default:
+		return fmt.Errorf("http status %d", status)
+	}
+}
+
+type AbuseIpDbOptions struct {
+	BaseUrl        string
+	Timeout        time.Duration
+	MaxAgeInDays   int
+	Verbose        bool
+	MinConfidence  int
+	IncludeReports bool
+	CacheTTL       time.Duration
+}
+
 type AbuseIpDbChecker struct {
 	httpClient *http.Client
 	apiKey     string
+	opts       AbuseIpDbOptions
+	cache      *ttlCache[netip.Addr, RiskInfo]
 }
 
-func NewAbuseIpDbChecker(apiKey string) *AbuseIpDbChecker {
+func NewAbuseIpDbChecker(apiKey string, opts AbuseIpDbOptions) *AbuseIpDbChecker {
+	if opts.BaseUrl == "" {
+		opts.BaseUrl = AbuseIpDbDefaultBaseUrl
+	}
+	opts.BaseUrl = strings.TrimSuffix(opts.BaseUrl, "/")
+	if opts.Timeout <= 0 {
+		opts.Timeout = AbuseIpDbDefaultTimeout
+	}
+	if opts.MaxAgeInDays <= 0 {
+		opts.MaxAgeInDays = AbuseIpDbDefaultMaxAgeInDays
+	}
+
 	return &AbuseIpDbChecker{
-		httpClient: &http.Client{Timeout: 500 * time.Millisecond},
+		httpClient: &http.Client{Timeout: opts.Timeout},
 		apiKey:     apiKey,
+		opts:       opts,
+		cache:      newTTLCache[netip.Addr, RiskInfo](opts.CacheTTL, abuseIpDbCheckCacheSize),
 	}
 }
 
 func (c AbuseIpDbChecker) Enrich(ip net.IP, out *LookupResult) error {
+	addr, _ := netIPToNetipAddr(ip)
+	addr = addr.Unmap()
+	if risk, ok := c.cache.Get(addr); ok {
+		out.Risk = risk
+		out.Risk.Status = DataCached
+		return nil
+	}
+
 	params := url.Values{}
 	params.Add("ipAddress", ip.String())
-	params.Add("maxAgeInDays", "90")
-	params.Add("verbose", "")
+	params.Add("maxAgeInDays", strconv.Itoa(c.opts.MaxAgeInDays))
+	if c.opts.Verbose {
+		params.Add("verbose", "")
+	}
 
-	abuseIpDbCheckerUrl := abuseIpDbCheckerBaseUrl + "?" + params.Encode()
+	abuseIpDbCheckerUrl := c.opts.BaseUrl + "/check?" + params.Encode()
 	req, err := http.NewRequest(http.MethodGet, abuseIpDbCheckerUrl, nil)
 	if err != nil {
 		return err
@@ -47,8 +109,8 @@ func (c AbuseIpDbChecker) Enrich(ip net.IP, out *LookupResult) error {
 	}
 	defer httpResponse.Body.Close()
 
-	if httpResponse.StatusCode != 200 {
-		return fmt.Errorf("http status %d", httpResponse.StatusCode)
+	if httpResponse.StatusCode != http.StatusOK {
+		return abuseIpDbStatusError(httpResponse.StatusCode)
 	}
 
 	httpBody, err := io.ReadAll(httpResponse.Body)
@@ -62,12 +124,26 @@ func (c AbuseIpDbChecker) Enrich(ip net.IP, out *LookupResult) error {
 	}
 
 	out.Risk = RiskInfo{
-		AbuseConfidenceScore:  res.Data.AbuseConfidenceScore,
-		UsageType:             res.Data.UsageType,
-		IsTor:                 res.Data.IsTor,
-		TotalReports:          res.Data.TotalReports,
-		NumberOfUsersReported: res.Data.NumDistinctUsers,
-		LastReportedAt:        res.Data.LastReportedAt,
+		Status:                DataChecked,
+		CheckedAt:             ptr(time.Now().UTC()),
+		AbuseConfidenceScore:  ptr(res.Data.AbuseConfidenceScore),
+		IsAbusive:             ptr(res.Data.AbuseConfidenceScore >= c.opts.MinConfidence),
+		UsageType:             ptr(res.Data.UsageType),
+		IsTor:                 ptr(res.Data.IsTor),
+		TotalReports:          ptr(res.Data.TotalReports),
+		NumberOfUsersReported: ptr(res.Data.NumDistinctUsers),
+	}
+	// Addresses that were never reported have no lastReportedAt.
+	if !res.Data.LastReportedAt.IsZero() {
+		out.Risk.LastReportedAt = ptr(res.Data.LastReportedAt)
+	}
+
+	if c.opts.Verbose && c.opts.IncludeReports {
+		out.Risk.Reports = buildRiskReports(res)
+	}
+
+	if addr.IsValid() {
+		c.cache.Set(addr, out.Risk)
 	}
 
 	return nil
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AbuseIpDbDefaultBlockMinPrefixV4 = 24
+	AbuseIpDbDefaultBlockMinPrefixV6 = 112
+	AbuseIpDbDefaultBlockCacheTTL    = time.Hour
+	abuseIpDbBlockCacheSize          = 1024
+)
+
+var ErrBlockTooLarge = errors.New("network is larger than allowed")
+
+type AbuseIpDbBlockOptions struct {
+	BaseUrl      string
+	Timeout      time.Duration
+	MaxAgeInDays int
+	MinPrefixV4  int
+	MinPrefixV6  int
+	CacheTTL     time.Duration
+}
+
+type AbuseIpDbBlockChecker struct {
+	httpClient *http.Client
+	apiKey     string
+	opts       AbuseIpDbBlockOptions
+	cache      *ttlCache[netip.Prefix, BlockRiskResult]
+}
+
+func NewAbuseIpDbBlockChecker(apiKey string, opts AbuseIpDbBlockOptions) *AbuseIpDbBlockChecker {
+	if opts.BaseUrl == "" {
+		opts.BaseUrl = AbuseIpDbDefaultBaseUrl
+	}
+	opts.BaseUrl = strings.TrimSuffix(opts.BaseUrl, "/")
+	if opts.Timeout <= 0 {
+		opts.Timeout = AbuseIpDbDefaultTimeout
+	}
+	if opts.MaxAgeInDays <= 0 {
+		opts.MaxAgeInDays = AbuseIpDbDefaultMaxAgeInDays
+	}
+	if opts.MinPrefixV4 <= 0 {
+		opts.MinPrefixV4 = AbuseIpDbDefaultBlockMinPrefixV4
+	}
+	if opts.MinPrefixV6 <= 0 {
+		opts.MinPrefixV6 = AbuseIpDbDefaultBlockMinPrefixV6
+	}
+
+	return &AbuseIpDbBlockChecker{
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		apiKey:     apiKey,
+		opts:       opts,
+		cache:      newTTLCache[netip.Prefix, BlockRiskResult](opts.CacheTTL, abuseIpDbBlockCacheSize),
+	}
+}
+
+// ParseBlock parses a CIDR and checks it against the largest network the
+// check-block endpoint accepts.
+func (c *AbuseIpDbBlockChecker) ParseBlock(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("invalid ipv4-mapped prefix %s", prefix)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	prefix = prefix.Masked()
+
+	minBits := c.opts.MinPrefixV4
+	if prefix.Addr().Is6() {
+		minBits = c.opts.MinPrefixV6
+	}
+	if prefix.Bits() < minBits {
+		return netip.Prefix{}, fmt.Errorf("%w: /%d, smallest allowed prefix is /%d", ErrBlockTooLarge, prefix.Bits(), minBits)
+	}
+	return prefix, nil
+}
+
+func (c *AbuseIpDbBlockChecker) CheckBlock(prefix netip.Prefix) (BlockRiskResult, error) {
+	if res, ok := c.cache.Get(prefix); ok {
+		res.Cached = true
+		return res, nil
+	}
+
+	params := url.Values{}
+	params.Add("network", prefix.String())
+	params.Add("maxAgeInDays", strconv.Itoa(c.opts.MaxAgeInDays))
+
+	req, err := http.NewRequest(http.MethodGet, c.opts.BaseUrl+"/check-block?"+params.Encode(), nil)
+	if err != nil {
+		return BlockRiskResult{}, err
+	}
+
+	req.Header.Add("Key", c.apiKey)
+	req.Header.Add("Accept", "application/json")
+
+	httpResponse, err := c.httpClient.Do(req)
+	if err != nil {
+		return BlockRiskResult{}, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return BlockRiskResult{}, abuseIpDbStatusError(httpResponse.StatusCode)
+	}
+
+	httpBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return BlockRiskResult{}, err
+	}
+
+	var res AbuseIpDbCheckBlockResult
+	if err := json.Unmarshal(httpBody, &res); err != nil {
+		return BlockRiskResult{}, err
+	}
+
+	out := BlockRiskResult{
+		Network:           prefix.String(),
+		NetworkAddress:    res.Data.NetworkAddress,
+		Netmask:           res.Data.Netmask,
+		MinAddress:        res.Data.MinAddress,
+		MaxAddress:        res.Data.MaxAddress,
+		NumPossibleHosts:  res.Data.NumPossibleHosts,
+		AddressSpaceDesc:  res.Data.AddressSpaceDesc,
+		ReportedAddresses: make([]BlockReportedAddress, 0, len(res.Data.ReportedAddress)),
+		CheckedAt:         time.Now().UTC(),
+	}
+
+	for _, ra := range res.Data.ReportedAddress {
+		var categories []string
+		for _, id := range ra.Categories {
+			categories = append(categories, AbuseIpDbCategoryName(id))
+		}
+		out.ReportedAddresses = append(out.ReportedAddresses, BlockReportedAddress{
+			IP:                   ra.IpAddress,
+			NumReports:           ra.NumReports,
+			AbuseConfidenceScore: ra.AbuseConfidenceScore,
+			CountryCode:          ra.CountryCode,
+			Categories:           categories,
+			LastReportedAt:       ra.MostRecentReport,
+		})
+	}
+
+	c.cache.Set(prefix, out)
+	return out, nil
+}
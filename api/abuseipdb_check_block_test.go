@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const abuseIpDbCheckBlockBody = `{"data": {"networkAddress": "203.0.113.0", "netmask": "255.255.255.0", "minAddress": "203.0.113.1", "maxAddress": "203.0.113.254", "numPossibleHosts": 254, "addressSpaceDesc": "Internet", "reportedAddress": [{"ipAddress": "203.0.113.7", "numReports": 5, "mostRecentReport": "2026-10-01T12:00:00+00:00", "abuseConfidenceScore": 80, "countryCode": "NL", "categories": [18, 22]}]}}`
+
+func TestParseBlock(t *testing.T) {
+	c := NewAbuseIpDbBlockChecker("secret", AbuseIpDbBlockOptions{})
+
+	tests := []struct {
+		cidr     string
+		want     string
+		tooLarge bool
+		invalid  bool
+	}{
+		{cidr: "203.0.113.0/24", want: "203.0.113.0/24"},
+		{cidr: " 203.0.113.77/24 ", want: "203.0.113.0/24"},
+		{cidr: "203.0.113.7/32", want: "203.0.113.7/32"},
+		{cidr: "203.0.112.0/23", tooLarge: true},
+		{cidr: "0.0.0.0/0", tooLarge: true},
+		{cidr: "2001:db8::/112", want: "2001:db8::/112"},
+		{cidr: "2001:db8::/64", tooLarge: true},
+		{cidr: "::ffff:203.0.113.0/120", want: "203.0.113.0/24"},
+		{cidr: "::ffff:203.0.112.0/119", tooLarge: true},
+		{cidr: "::ffff:0.0.0.0/80", invalid: true},
+		{cidr: "203.0.113.0", invalid: true},
+	}
+	for _, tt := range tests {
+		got, err := c.ParseBlock(tt.cidr)
+		switch {
+		case tt.tooLarge:
+			if !errors.Is(err, ErrBlockTooLarge) {
+				t.Errorf("ParseBlock(%q) = %v, %v, want ErrBlockTooLarge", tt.cidr, got, err)
+			}
+		case tt.invalid:
+			if err == nil || errors.Is(err, ErrBlockTooLarge) {
+				t.Errorf("ParseBlock(%q) = %v, %v, want a parse error", tt.cidr, got, err)
+			}
+		case err != nil || got.String() != tt.want:
+			t.Errorf("ParseBlock(%q) = %v, %v, want %s", tt.cidr, got, err, tt.want)
+		}
+	}
+
+	// The limits are configurable.
+	c = NewAbuseIpDbBlockChecker("secret", AbuseIpDbBlockOptions{MinPrefixV4: 16, MinPrefixV6: 48})
+	for _, cidr := range []string{"198.51.0.0/16", "2001:db8::/48"} {
+		if _, err := c.ParseBlock(cidr); err != nil {
+			t.Errorf("ParseBlock(%q) with lower limits: %v", cidr, err)
+		}
+	}
+	if _, err := c.ParseBlock("198.0.0.0/15"); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("ParseBlock(198.0.0.0/15) = %v, want ErrBlockTooLarge", err)
+	}
+}
+
+func TestCheckBlock(t *testing.T) {
+	srv, calls := abuseIpDbStandIn(t, "/check-block", http.StatusOK, abuseIpDbCheckBlockBody, func(r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("network") != "203.0.113.0/24" {
+			t.Errorf("network = %q", q.Get("network"))
+		}
+		if q.Get("maxAgeInDays") != "30" {
+			t.Errorf("maxAgeInDays = %q, want 30", q.Get("maxAgeInDays"))
+		}
+	})
+	c := NewAbuseIpDbBlockChecker("secret", AbuseIpDbBlockOptions{BaseUrl: srv.URL, MaxAgeInDays: 30, CacheTTL: time.Minute})
+	prefix := netip.MustParsePrefix("203.0.113.0/24")
+
+	res, err := c.CheckBlock(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cached || res.Network != "203.0.113.0/24" || res.NumPossibleHosts != 254 || res.Netmask != "255.255.255.0" {
+		t.Errorf("result = %+v", res)
+	}
+	if len(res.ReportedAddresses) != 1 {
+		t.Fatalf("reported addresses = %+v, want one", res.ReportedAddresses)
+	}
+	ra := res.ReportedAddresses[0]
+	if ra.IP != "203.0.113.7" || ra.NumReports != 5 || ra.AbuseConfidenceScore != 80 || len(ra.Categories) != 2 {
+		t.Errorf("reported address = %+v", ra)
+	}
+
+	res, err = c.CheckBlock(prefix)
+	if err != nil || !res.Cached {
+		t.Errorf("second check: cached = %v, err = %v, want the cached result", res.Cached, err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestCheckBlockStatus(t *testing.T) {
+	tests := []struct {
+		status   int
+		want     error
+		wantCode int
+	}{
+		{status: http.StatusUnauthorized, want: ErrAbuseIpDbUnauthorized, wantCode: http.StatusBadGateway},
+		{status: http.StatusTooManyRequests, want: ErrAbuseIpDbRateLimited, wantCode: http.StatusTooManyRequests},
+		{status: http.StatusInternalServerError, wantCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv, calls := abuseIpDbStandIn(t, "/check-block", tt.status, `{"errors": [{"detail": "nope"}]}`, nil)
+			c := NewAbuseIpDbBlockChecker("secret", AbuseIpDbBlockOptions{BaseUrl: srv.URL, CacheTTL: time.Minute})
+
+			_, err := c.CheckBlock(netip.MustParsePrefix("203.0.113.0/24"))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+
+			// The handler maps the error; failures aren't cached.
+			s := &Server{BlockChecker: c}
+			rr := httptest.NewRecorder()
+			s.GetBlockRisk(rr, blockRiskRequest("203.0.113.0/24"))
+			if rr.Code != tt.wantCode {
+				t.Errorf("handler status = %d, want %d", rr.Code, tt.wantCode)
+			}
+			if n := calls.Load(); n != 2 {
+				t.Errorf("upstream calls = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestGetBlockRiskTooLarge(t *testing.T) {
+	srv, calls := abuseIpDbStandIn(t, "/check-block", http.StatusOK, abuseIpDbCheckBlockBody, nil)
+	s := &Server{BlockChecker: NewAbuseIpDbBlockChecker("secret", AbuseIpDbBlockOptions{BaseUrl: srv.URL})}
+
+	rr := httptest.NewRecorder()
+	s.GetBlockRisk(rr, blockRiskRequest("203.0.112.0/23"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rr.Code)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("upstream calls = %d, want 0", n)
+	}
+}
+
+// blockRiskRequest builds a /risk/block request with the chi wildcard set.
+func blockRiskRequest(cidr string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("*", cidr)
+	r := httptest.NewRequest(http.MethodGet, "/risk/block/"+cidr, nil)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
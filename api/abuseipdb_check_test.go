@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const abuseIpDbCheckBody = `{"data": {"ipAddress": "203.0.113.7", "isPublic": true, "abuseConfidenceScore": 80, "usageType": "Data Center/Web Hosting/Transit", "domain": "example.net", "isTor": false, "totalReports": 12, "numDistinctUsers": 4, "lastReportedAt": "2026-10-01T12:00:00+00:00", "reports": [{"reportedAt": "2026-10-01T12:00:00+00:00", "comment": "ssh brute force", "categories": [18, 22], "reporterCountryCode": "DE"}]}}`
+
+// abuseIpDbStandIn answers every request to path with status and body, and
+// hands the request to inspect first.
+func abuseIpDbStandIn(t *testing.T, path string, status int, body string, inspect func(*http.Request)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != path {
+			t.Errorf("path = %s, want %s", r.URL.Path, path)
+		}
+		if r.Header.Get("Key") != "secret" {
+			t.Errorf("Key header = %q, want the api key", r.Header.Get("Key"))
+		}
+		if inspect != nil {
+			inspect(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestAbuseIpDbCheckerParams(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         AbuseIpDbOptions
+		maxAgeInDays string
+		verbose      bool
+		reports      bool
+	}{
+		{name: "defaults", maxAgeInDays: "90"},
+		{name: "max age", opts: AbuseIpDbOptions{MaxAgeInDays: 30}, maxAgeInDays: "30"},
+		{name: "verbose", opts: AbuseIpDbOptions{Verbose: true}, maxAgeInDays: "90", verbose: true},
+		{name: "verbose with reports", opts: AbuseIpDbOptions{Verbose: true, IncludeReports: true}, maxAgeInDays: "90", verbose: true, reports: true},
+		{name: "reports need verbose", opts: AbuseIpDbOptions{IncludeReports: true}, maxAgeInDays: "90"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := abuseIpDbStandIn(t, "/check", http.StatusOK, abuseIpDbCheckBody, func(r *http.Request) {
+				q := r.URL.Query()
+				if q.Get("ipAddress") != "203.0.113.7" {
+					t.Errorf("ipAddress = %q", q.Get("ipAddress"))
+				}
+				if q.Get("maxAgeInDays") != tt.maxAgeInDays {
+					t.Errorf("maxAgeInDays = %q, want %q", q.Get("maxAgeInDays"), tt.maxAgeInDays)
+				}
+				if q.Has("verbose") != tt.verbose {
+					t.Errorf("verbose sent = %v, want %v", q.Has("verbose"), tt.verbose)
+				}
+			})
+			opts := tt.opts
+			opts.BaseUrl = srv.URL
+			c := NewAbuseIpDbChecker("secret", opts)
+
+			var res LookupResult
+			if err := c.Enrich(net.ParseIP("203.0.113.7"), &res); err != nil {
+				t.Fatal(err)
+			}
+			if (res.Risk.Reports != nil) != tt.reports {
+				t.Errorf("reports = %+v, want included = %v", res.Risk.Reports, tt.reports)
+			}
+		})
+	}
+}
+
+func TestAbuseIpDbCheckerResult(t *testing.T) {
+	srv, calls := abuseIpDbStandIn(t, "/check", http.StatusOK, abuseIpDbCheckBody, nil)
+	c := NewAbuseIpDbChecker("secret", AbuseIpDbOptions{BaseUrl: srv.URL + "/", MinConfidence: 75, CacheTTL: time.Minute})
+
+	var res LookupResult
+	if err := c.Enrich(net.ParseIP("203.0.113.7"), &res); err != nil {
+		t.Fatal(err)
+	}
+	risk := res.Risk
+	if risk.Status != DataChecked || risk.CheckedAt == nil {
+		t.Errorf("status = %s, checked_at = %v", risk.Status, risk.CheckedAt)
+	}
+	if deref(risk.AbuseConfidenceScore) != 80 || !deref(risk.IsAbusive) || deref(risk.IsTor) {
+		t.Errorf("score = %v, abusive = %v, tor = %v", risk.AbuseConfidenceScore, risk.IsAbusive, risk.IsTor)
+	}
+	if deref(risk.TotalReports) != 12 || deref(risk.NumberOfUsersReported) != 4 || risk.LastReportedAt == nil {
+		t.Errorf("reports = %v, users = %v, last = %v", risk.TotalReports, risk.NumberOfUsersReported, risk.LastReportedAt)
+	}
+
+	// The mapped form of the same address comes from the cache.
+	res = LookupResult{}
+	if err := c.Enrich(net.ParseIP("::ffff:203.0.113.7"), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Risk.Status != DataCached || deref(res.Risk.AbuseConfidenceScore) != 80 {
+		t.Errorf("status = %s, score = %v, want the cached answer", res.Risk.Status, res.Risk.AbuseConfidenceScore)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestAbuseIpDbCheckerStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{status: http.StatusUnauthorized, want: ErrAbuseIpDbUnauthorized},
+		{status: http.StatusForbidden, want: ErrAbuseIpDbUnauthorized},
+		{status: http.StatusTooManyRequests, want: ErrAbuseIpDbRateLimited},
+		{status: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv, calls := abuseIpDbStandIn(t, "/check", tt.status, `{"errors": [{"detail": "nope"}]}`, nil)
+			c := NewAbuseIpDbChecker("secret", AbuseIpDbOptions{BaseUrl: srv.URL, CacheTTL: time.Minute})
+
+			var res LookupResult
+			err := c.Enrich(net.ParseIP("203.0.113.7"), &res)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (errors.Is(err, ErrAbuseIpDbUnauthorized) || errors.Is(err, ErrAbuseIpDbRateLimited)) {
+				t.Errorf("err = %v, want a plain status error", err)
+			}
+			if res.Risk.Status != "" || res.Risk.AbuseConfidenceScore != nil {
+				t.Errorf("risk = %+v, want it untouched", res.Risk)
+			}
+
+			// Failures aren't cached.
+			_ = c.Enrich(net.ParseIP("203.0.113.7"), &res)
+			if n := calls.Load(); n != 2 {
+				t.Errorf("upstream calls = %d, want 2", n)
+			}
+		})
+	}
+}
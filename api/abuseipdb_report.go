@@ -0,0 +1,367 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AbuseIpDbReportWindow          = 15 * time.Minute
+	AbuseIpDbDefaultDailyQuota     = 1000
+	AbuseIpDbDefaultReportQueue    = 256
+	AbuseIpDbReportDefaultTimeout  = 10 * time.Second
+	AbuseIpDbReportDefaultDrain    = 10 * time.Second
+	abuseIpDbReportCommentMaxBytes = 1024
+)
+
+var (
+	ErrReportDuplicate     = errors.New("ip already reported within the last 15 minutes")
+	ErrReportQuotaExceeded = errors.New("daily report quota exceeded")
+	ErrReportQueueFull     = errors.New("report queue is full")
+
+	// errReportUnconfirmed means the report was sent, but no answer came
+	// back, so AbuseIPDB may well have accepted it.
+	errReportUnconfirmed = errors.New("report sent, but not confirmed")
+)
+
+type AbuseReport struct {
+	IP         string    `json:"ip"`
+	Categories []int     `json:"categories"`
+	Comment    string    `json:"comment"`
+	Timestamp  time.Time `json:"timestamp,omitempty"`
+}
+
+func (r *AbuseReport) Validate() error {
+	ip := net.ParseIP(strings.TrimSpace(r.IP))
+	if ip == nil {
+		return fmt.Errorf("invalid ip %q", r.IP)
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return fmt.Errorf("ip %s is not public", ip)
+	}
+	r.IP = ip.String()
+
+	if len(r.Categories) == 0 {
+		return errors.New("at least one category is required")
+	}
+	for _, id := range r.Categories {
+		if _, ok := abuseIpDbCategories[id]; !ok {
+			return fmt.Errorf("unknown category %d", id)
+		}
+	}
+
+	r.Comment = strings.TrimSpace(r.Comment)
+	if len(r.Comment) > abuseIpDbReportCommentMaxBytes {
+		return fmt.Errorf("comment exceeds %d bytes", abuseIpDbReportCommentMaxBytes)
+	}
+	return nil
+}
+
+type AbuseIpDbReporterOptions struct {
+	BaseUrl string
+	// Timeout limits each report. It is longer than that of checks, as a
+	// report that times out can't be told apart from an accepted one.
+	Timeout    time.Duration
+	DailyQuota int
+	QueueSize  int
+	// DrainTimeout limits how long Close keeps sending queued reports.
+	DrainTimeout time.Duration
+}
+
+type AbuseIpDbReporter struct {
+	httpClient *http.Client
+	apiKey     string
+	opts       AbuseIpDbReporterOptions
+
+	queue chan AbuseReport
+	done  chan struct{}
+	wg    sync.WaitGroup
+	// ctx is cancelled once Close has waited DrainTimeout.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	lastReport map[string]time.Time
+	quotaDay   string
+	quotaUsed  int
+	blockedTil time.Time
+}
+
+func NewAbuseIpDbReporter(apiKey string, opts AbuseIpDbReporterOptions) *AbuseIpDbReporter {
+	if opts.BaseUrl == "" {
+		opts.BaseUrl = AbuseIpDbDefaultBaseUrl
+	}
+	opts.BaseUrl = strings.TrimSuffix(opts.BaseUrl, "/")
+	if opts.Timeout <= 0 {
+		opts.Timeout = AbuseIpDbReportDefaultTimeout
+	}
+	if opts.DailyQuota <= 0 {
+		opts.DailyQuota = AbuseIpDbDefaultDailyQuota
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = AbuseIpDbReportDefaultDrain
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = AbuseIpDbDefaultReportQueue
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AbuseIpDbReporter{
+		ctx:        ctx,
+		cancel:     cancel,
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		apiKey:     apiKey,
+		opts:       opts,
+		queue:      make(chan AbuseReport, opts.QueueSize),
+		done:       make(chan struct{}),
+		lastReport: map[string]time.Time{},
+	}
+}
+
+func (c *AbuseIpDbReporter) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Close sends the reports still queued, for at most DrainTimeout, and stops
+// the reporter. Reports left over are dropped and counted in the log.
+func (c *AbuseIpDbReporter) Close() error {
+	close(c.done)
+	deadline := time.AfterFunc(c.opts.DrainTimeout, c.cancel)
+	c.wg.Wait()
+	deadline.Stop()
+	c.cancel()
+	return nil
+}
+
+// Enqueue accepts a validated report for delivery. Reports for an IP that was
+// already accepted within AbuseIpDbReportWindow are rejected, as AbuseIPDB
+// would refuse them anyway, and so are reports once the daily quota is spent.
+func (c *AbuseIpDbReporter) Enqueue(rep AbuseReport) error {
+	now := time.Now().UTC()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last, ok := c.lastReport[rep.IP]; ok && now.Sub(last) < AbuseIpDbReportWindow {
+		return ErrReportDuplicate
+	}
+
+	c.resetQuota(now)
+	if now.Before(c.blockedTil) || c.quotaUsed >= c.opts.DailyQuota {
+		return ErrReportQuotaExceeded
+	}
+
+	select {
+	case c.queue <- rep:
+	default:
+		return ErrReportQueueFull
+	}
+
+	c.lastReport[rep.IP] = now
+	c.quotaUsed++
+	return nil
+}
+
+// resetQuota starts a new quota period at UTC midnight. Callers hold c.mu.
+func (c *AbuseIpDbReporter) resetQuota(now time.Time) {
+	day := now.Format(time.DateOnly)
+	if c.quotaDay != day {
+		c.quotaDay = day
+		c.quotaUsed = 0
+	}
+}
+
+func (c *AbuseIpDbReporter) run() {
+	defer c.wg.Done()
+
+	prune := time.NewTicker(AbuseIpDbReportWindow)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			c.drain()
+			return
+		case rep := <-c.queue:
+			c.deliver(c.ctx, rep)
+		case now := <-prune.C:
+			c.pruneReported(now.UTC())
+		}
+	}
+}
+
+// drain sends the queued reports until the queue is empty or Close stops
+// waiting.
+func (c *AbuseIpDbReporter) drain() {
+	dropped := 0
+	for {
+		select {
+		case rep := <-c.queue:
+			if c.ctx.Err() != nil {
+				dropped++
+				continue
+			}
+			c.deliver(c.ctx, rep)
+		default:
+			if dropped > 0 {
+				log.Printf("abuseipdb reporter closed, %d queued reports dropped", dropped)
+			}
+			return
+		}
+	}
+}
+
+func (c *AbuseIpDbReporter) deliver(ctx context.Context, rep AbuseReport) {
+	err := c.send(ctx, rep)
+	switch {
+	case err == nil:
+	case errors.Is(err, errReportUnconfirmed):
+		// Refunding could report the IP twice, which AbuseIPDB counts
+		// against the quota all the same.
+		log.Printf("abuseipdb report %s: %v", rep.IP, err)
+	default:
+		c.refund(rep, errors.Is(err, ErrReportDuplicate))
+		log.Printf("abuseipdb report %s failed: %v", rep.IP, err)
+	}
+}
+
+// refund returns the quota Enqueue took for a report that wasn't accepted.
+// Unless AbuseIPDB already holds a recent report for the IP, the IP may be
+// reported again right away.
+func (c *AbuseIpDbReporter) refund(rep AbuseReport, duplicate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resetQuota(time.Now().UTC())
+	if c.quotaUsed > 0 {
+		c.quotaUsed--
+	}
+	if !duplicate {
+		delete(c.lastReport, rep.IP)
+	}
+}
+
+func (c *AbuseIpDbReporter) pruneReported(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for ip, last := range c.lastReport {
+		if now.Sub(last) >= AbuseIpDbReportWindow {
+			delete(c.lastReport, ip)
+		}
+	}
+}
+
+func (c *AbuseIpDbReporter) send(ctx context.Context, rep AbuseReport) error {
+	categories := make([]string, 0, len(rep.Categories))
+	for _, id := range rep.Categories {
+		categories = append(categories, strconv.Itoa(id))
+	}
+
+	form := url.Values{}
+	form.Add("ip", rep.IP)
+	form.Add("categories", strings.Join(categories, ","))
+	if rep.Comment != "" {
+		form.Add("comment", rep.Comment)
+	}
+	if !rep.Timestamp.IsZero() {
+		form.Add("timestamp", rep.Timestamp.Format(time.RFC3339))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.BaseUrl+"/report", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Key", c.apiKey)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	httpResponse, err := c.httpClient.Do(req)
+	if err != nil {
+		// Only a failed dial means the report never left.
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errReportUnconfirmed, err)
+	}
+	defer httpResponse.Body.Close()
+
+	// AbuseIPDB answers 429 both when the quota is spent and when the IP was
+	// reported within the last 15 minutes, possibly by another instance. Only
+	// the former carries rate limit headers and blocks all reports.
+	if httpResponse.StatusCode == http.StatusTooManyRequests {
+		if until, ok := quotaReset(httpResponse.Header); ok {
+			c.blockUntil(until)
+			return ErrReportQuotaExceeded
+		}
+		return ErrReportDuplicate
+	}
+
+	if httpResponse.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 512))
+		return fmt.Errorf("http status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var res struct {
+		Data struct {
+			IpAddress            string `json:"ipAddress"`
+			AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(httpResponse.Body).Decode(&res); err != nil {
+		// Accepted all the same, so it isn't refunded.
+		log.Printf("abuseipdb reported ip=%s categories=%v, unreadable response: %v", rep.IP, rep.Categories, err)
+		return nil
+	}
+
+	log.Printf("abuseipdb reported ip=%s categories=%v score=%d", rep.IP, rep.Categories, res.Data.AbuseConfidenceScore)
+	return nil
+}
+
+func (c *AbuseIpDbReporter) blockUntil(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.After(c.blockedTil) {
+		c.blockedTil = t
+	}
+}
+
+// quotaReset reports whether a 429 response is about the quota, and when it
+// resets.
+func quotaReset(h http.Header) (time.Time, bool) {
+	if h.Get("Retry-After") != "" {
+		return retryAfter(h.Get("Retry-After")), true
+	}
+	if h.Get("X-RateLimit-Remaining") == "" && h.Get("X-RateLimit-Reset") == "" {
+		return time.Time{}, false
+	}
+	if reset, err := strconv.ParseInt(strings.TrimSpace(h.Get("X-RateLimit-Reset")), 10, 64); err == nil && reset > 0 {
+		return time.Unix(reset, 0).UTC(), true
+	}
+	return retryAfter(""), true
+}
+
+// retryAfter returns the instant named by a Retry-After header in seconds,
+// falling back to the next UTC midnight when the quota resets.
+func retryAfter(v string) time.Time {
+	now := time.Now().UTC()
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs > 0 {
+		return now.Add(time.Duration(secs) * time.Second)
+	}
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
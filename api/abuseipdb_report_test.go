@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAbuseIpDbReporter429(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		quota   bool
+	}{
+		{name: "duplicate"},
+		{name: "retry after", headers: map[string]string{"Retry-After": "3600"}, quota: true},
+		{name: "rate limit headers", headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "4102444800"}, quota: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer srv.Close()
+
+			c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, DailyQuota: 10})
+			rep := AbuseReport{IP: "203.0.113.7", Categories: []int{18}}
+			if err := c.Enqueue(rep); err != nil {
+				t.Fatal(err)
+			}
+			<-c.queue
+
+			err := c.send(context.Background(), rep)
+			if tt.quota && err != ErrReportQuotaExceeded || !tt.quota && err != ErrReportDuplicate {
+				t.Fatalf("send: err = %v", err)
+			}
+			c.refund(rep, err == ErrReportDuplicate)
+
+			if c.quotaUsed != 0 {
+				t.Errorf("quota not refunded: %d used", c.quotaUsed)
+			}
+			err = c.Enqueue(AbuseReport{IP: "203.0.113.8", Categories: []int{18}})
+			if tt.quota && err != ErrReportQuotaExceeded || !tt.quota && err != nil {
+				t.Errorf("other ip: err = %v", err)
+			}
+			if !tt.quota {
+				if err := c.Enqueue(rep); err != ErrReportDuplicate {
+					t.Errorf("same ip: err = %v, want ErrReportDuplicate", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAbuseIpDbReporterRefund(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errors":[]}`, http.StatusUnprocessableEntity)
+	}))
+	defer srv.Close()
+
+	c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, DailyQuota: 1})
+	c.Start()
+	defer c.Close()
+
+	rep := AbuseReport{IP: "203.0.113.7", Categories: []int{18}}
+	if err := c.Enqueue(rep); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refund makes room for the same IP again.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := c.Enqueue(rep)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("report wasn't refunded: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAbuseIpDbReporterUnconfirmed(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, Timeout: 50 * time.Millisecond, DailyQuota: 10})
+	rep := AbuseReport{IP: "203.0.113.7", Categories: []int{18}}
+	if err := c.Enqueue(rep); err != nil {
+		t.Fatal(err)
+	}
+	c.deliver(context.Background(), <-c.queue)
+
+	// AbuseIPDB may have accepted the report, so it isn't refunded.
+	if c.quotaUsed != 1 {
+		t.Errorf("quota used = %d, want 1", c.quotaUsed)
+	}
+	if err := c.Enqueue(rep); err != ErrReportDuplicate {
+		t.Errorf("same ip: err = %v, want ErrReportDuplicate", err)
+	}
+}
+
+func TestAbuseIpDbReporterDialFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, DailyQuota: 10})
+	rep := AbuseReport{IP: "203.0.113.7", Categories: []int{18}}
+	if err := c.Enqueue(rep); err != nil {
+		t.Fatal(err)
+	}
+	c.deliver(context.Background(), <-c.queue)
+
+	// The report never left, so it may be sent again.
+	if c.quotaUsed != 0 {
+		t.Errorf("quota used = %d, want 0", c.quotaUsed)
+	}
+	if err := c.Enqueue(rep); err != nil {
+		t.Errorf("same ip: err = %v", err)
+	}
+}
+
+func TestAbuseIpDbReporterCloseDrains(t *testing.T) {
+	var sent atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		_, _ = w.Write([]byte(`{"data": {"abuseConfidenceScore": 0}}`))
+	}))
+	defer srv.Close()
+
+	// Not started, so everything is still queued when Close is called.
+	c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, DailyQuota: 10})
+	for _, ip := range []string{"203.0.113.7", "203.0.113.8", "203.0.113.9"} {
+		if err := c.Enqueue(AbuseReport{IP: ip, Categories: []int{18}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Start()
+	c.Close()
+
+	if n := sent.Load(); n != 3 {
+		t.Errorf("sent = %d, want 3", n)
+	}
+	if len(c.queue) != 0 {
+		t.Errorf("%d reports left in the queue", len(c.queue))
+	}
+}
+
+func TestAbuseIpDbReporterCloseDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c := NewAbuseIpDbReporter("key", AbuseIpDbReporterOptions{BaseUrl: srv.URL, DailyQuota: 10, DrainTimeout: 50 * time.Millisecond})
+	for _, ip := range []string{"203.0.113.7", "203.0.113.8", "203.0.113.9"} {
+		if err := c.Enqueue(AbuseReport{IP: ip, Categories: []int{18}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Start()
+
+	start := time.Now()
+	c.Close()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Close took %s, want about the drain timeout", d)
+	}
+	if len(c.queue) != 0 {
+		t.Errorf("%d reports left in the queue", len(c.queue))
+	}
+}
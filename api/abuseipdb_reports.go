@@ -0,0 +1,97 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	abuseIpDbLatestComments = 5
+)
+
+func buildRiskReports(res AbuseIpDbCheckResult) *RiskReports {
+	out := &RiskReports{
+		IsWhitelisted:     res.Data.IsWhitelisted,
+		IsPublic:          res.Data.IsPublic,
+		Domain:            res.Data.Domain,
+		Hostnames:         res.Data.Hostnames,
+		Categories:        []ReportCategoryCount{},
+		ReporterCountries: []ReporterCountryCount{},
+		LatestComments:    []ReportComment{},
+	}
+	if out.Hostnames == nil {
+		out.Hostnames = []string{}
+	}
+
+	categories := map[int]int{}
+	countries := map[string]*ReporterCountryCount{}
+
+	for _, rep := range res.Data.Reports {
+		for _, id := range rep.Categories {
+			categories[id]++
+		}
+
+		if rep.ReporterCountryCode != "" {
+			rc, ok := countries[rep.ReporterCountryCode]
+			if !ok {
+				rc = &ReporterCountryCount{
+					CountryCode: rep.ReporterCountryCode,
+					Country:     rep.ReporterCountryName,
+				}
+				countries[rep.ReporterCountryCode] = rc
+			}
+			rc.Count++
+		}
+	}
+
+	for id, n := range categories {
+		out.Categories = append(out.Categories, ReportCategoryCount{
+			ID:    id,
+			Name:  AbuseIpDbCategoryName(id),
+			Count: n,
+		})
+	}
+	sort.Slice(out.Categories, func(i, j int) bool {
+		if out.Categories[i].Count != out.Categories[j].Count {
+			return out.Categories[i].Count > out.Categories[j].Count
+		}
+		return out.Categories[i].ID < out.Categories[j].ID
+	})
+
+	for _, rc := range countries {
+		out.ReporterCountries = append(out.ReporterCountries, *rc)
+	}
+	sort.Slice(out.ReporterCountries, func(i, j int) bool {
+		if out.ReporterCountries[i].Count != out.ReporterCountries[j].Count {
+			return out.ReporterCountries[i].Count > out.ReporterCountries[j].Count
+		}
+		return out.ReporterCountries[i].CountryCode < out.ReporterCountries[j].CountryCode
+	})
+
+	reports := make([]AbuseIpDbReport, 0, len(res.Data.Reports))
+	for _, rep := range res.Data.Reports {
+		if strings.TrimSpace(rep.Comment) != "" {
+			reports = append(reports, rep)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].ReportedAt.After(reports[j].ReportedAt)
+	})
+	if len(reports) > abuseIpDbLatestComments {
+		reports = reports[:abuseIpDbLatestComments]
+	}
+
+	for _, rep := range reports {
+		names := make([]string, 0, len(rep.Categories))
+		for _, id := range rep.Categories {
+			names = append(names, AbuseIpDbCategoryName(id))
+		}
+		out.LatestComments = append(out.LatestComments, ReportComment{
+			ReportedAt: rep.ReportedAt,
+			Comment:    strings.TrimSpace(rep.Comment),
+			Categories: names,
+		})
+	}
+
+	return out
+}
@@ -18,6 +18,8 @@ func (w *ResponseWriterWithStatusCode) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+// AccessLogger logs every request but health checks, with the client address
+// resolved by ClientIPResolver if it ran before, or else by getClientIp.
 func AccessLogger(getClientIp ClientIpFunc) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,9 +37,24 @@ func AccessLogger(getClientIp ClientIpFunc) func(http.Handler) http.Handler {
 
 			next.ServeHTTP(ww, r)
 
+			ip, via := "-", ""
+			if info, ok := ClientIPInfoFromContext(r.Context()); ok {
+				if info.Addr.IsValid() {
+					ip = info.Addr.String()
+				}
+				if info.Preset != "" {
+					via = " preset=" + info.Preset
+				}
+			} else if getClientIp != nil {
+				if v := getClientIp(r); v != "" {
+					ip = v
+				}
+			}
+
 			log.Printf(
-				`ip=%s method=%s path=%s status=%d duration=%s agent=%q`,
-				getClientIp(r),
+				`ip=%s%s method=%s path=%s status=%d duration=%s agent=%q`,
+				ip,
+				via,
 				r.Method,
 				r.URL.Path,
 				ww.status,
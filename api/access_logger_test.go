@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := &LookupClient{TrustedProxies: mustCIDRs(t, "10.0.0.0/8")}
+	getClientIp := func(*http.Request) string { return "192.0.2.1" }
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    string
+	}{
+		{name: "resolved", handler: ClientIPResolver(c)(AccessLogger(getClientIp)(noop)), want: "ip=203.0.113.7 "},
+		// Without ClientIPResolver, as in routers built before it existed.
+		{name: "fallback", handler: AccessLogger(getClientIp)(noop), want: "ip=192.0.2.1 "},
+		{name: "none", handler: AccessLogger(nil)(noop), want: "ip=- "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			r := httptest.NewRequest(http.MethodGet, "/own", nil)
+			r.RemoteAddr = "10.0.0.1:5555"
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			tt.handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ApiKeyAuth only lets requests through that carry one of keys, either as
+// "Authorization: Bearer <key>" or as "X-API-Key: <key>".
+func ApiKeyAuth(keys []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !validApiKey(requestApiKey(r), keys) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ipquery"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func requestApiKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+func validApiKey(got string, keys []string) bool {
+	if got == "" {
+		return false
+	}
+	ok := false
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(k)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
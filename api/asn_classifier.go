@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type NetworkType string
+
+const (
+	NetworkVpn         NetworkType = "vpn"
+	NetworkHosting     NetworkType = "hosting"
+	NetworkResidential NetworkType = "residential"
+	NetworkMobile      NetworkType = "mobile"
+	NetworkEducation   NetworkType = "education"
+	NetworkGovernment  NetworkType = "government"
+)
+
+var networkTypes = map[NetworkType]struct{}{
+	NetworkVpn:         {},
+	NetworkHosting:     {},
+	NetworkResidential: {},
+	NetworkMobile:      {},
+	NetworkEducation:   {},
+	NetworkGovernment:  {},
+}
+
+// AsnClassifier tags networks from curated text files with one entry per
+// line, an ASN or a CIDR followed by its type:
+//
+//	AS16509        hosting      # Amazon
+//	AS39351        vpn          # 31173 Services AB (Mullvad)
+//	203.0.113.0/24 education
+//
+// Prefix entries take precedence over ASN entries, and later files over
+// earlier ones.
+type AsnClassifier struct {
+	sources []string
+
+	mu       sync.RWMutex
+	asns     map[uint]NetworkType
+	prefixes *prefixTrie[prefixType]
+
+	loop refreshLoop
+}
+
+type prefixType struct {
+	prefix netip.Prefix
+	typ    NetworkType
+}
+
+func NewAsnClassifier(sources []string) *AsnClassifier {
+	return &AsnClassifier{
+		sources:  sources,
+		asns:     map[uint]NetworkType{},
+		prefixes: newPrefixTrie[prefixType](),
+	}
+}
+
+// Start loads the datasets and reloads them every interval, if positive.
+func (c *AsnClassifier) Start(interval time.Duration) {
+	if err := c.Refresh(); err != nil {
+		log.Printf("asn classifier: %v", err)
+	}
+	c.loop.start(interval, func() {
+		if err := c.Refresh(); err != nil {
+			log.Printf("asn classifier: %v", err)
+		}
+	})
+}
+
+func (c *AsnClassifier) Close() error {
+	c.loop.stop()
+	return nil
+}
+
+// Refresh reloads all datasets. If any of them fails to load, the previous
+// classification is kept.
+func (c *AsnClassifier) Refresh() error {
+	asns := map[uint]NetworkType{}
+	prefixes := newPrefixTrie[prefixType]()
+
+	for _, src := range c.sources {
+		rc, err := openSource(src)
+		if err != nil {
+			return err
+		}
+		err = parseAsnClassification(rc, asns, prefixes)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+	}
+
+	c.mu.Lock()
+	c.asns = asns
+	c.prefixes = prefixes
+	c.mu.Unlock()
+
+	log.Printf("asn classifier loaded: %d asns, %d prefixes", len(asns), prefixes.Len())
+	return nil
+}
+
+func parseAsnClassification(r io.Reader, asns map[uint]NetworkType, prefixes *prefixTrie[prefixType]) error {
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: expected \"<asn|cidr> <type>\"", line)
+		}
+
+		typ := NetworkType(strings.ToLower(fields[1]))
+		if _, ok := networkTypes[typ]; !ok {
+			return fmt.Errorf("line %d: unknown type %q", line, fields[1])
+		}
+
+		key := fields[0]
+		if upper := strings.ToUpper(key); strings.HasPrefix(upper, "AS") {
+			n, err := strconv.ParseUint(upper[2:], 10, 32)
+			if err != nil {
+				return fmt.Errorf("line %d: bad asn %q", line, key)
+			}
+			asns[uint(n)] = typ
+			continue
+		}
+
+		p, ok := parsePrefixOrAddr(key)
+		if !ok {
+			return fmt.Errorf("line %d: bad asn or cidr %q", line, key)
+		}
+		prefixes.Insert(p, prefixType{prefix: p, typ: typ})
+	}
+	return sc.Err()
+}
+
+func (c *AsnClassifier) Classify(addr netip.Addr, asn uint) (NetworkType, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if matches := c.prefixes.Lookup(addr); len(matches) > 0 {
+		// Lookup returns the shortest prefix first; the last entry of the
+		// most specific prefix wins.
+		return matches[len(matches)-1].typ, true
+	}
+
+	typ, ok := c.asns[asn]
+	return typ, ok
+}
+
+func (c *AsnClassifier) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+
+	asn, _ := strconv.ParseUint(strings.TrimPrefix(out.ISP.ASN, "AS"), 10, 32)
+
+	typ, ok := c.Classify(addr, uint(asn))
+	if !ok {
+		return nil
+	}
+
+	out.ISP.Type = string(typ)
+	out.ISP.IsVpn = typ == NetworkVpn
+	out.ISP.IsHosting = typ == NetworkHosting
+	out.ISP.IsResidential = typ == NetworkResidential
+	out.ISP.IsMobile = typ == NetworkMobile
+	out.ISP.IsEducation = typ == NetworkEducation
+	out.ISP.IsGovernment = typ == NetworkGovernment
+	return nil
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FeedFormat string
+
+const (
+	FeedFormatSpamhaus FeedFormat = "spamhaus"
+	FeedFormatFireHOL  FeedFormat = "firehol"
+	FeedFormatET       FeedFormat = "et"
+	FeedFormatPlain    FeedFormat = "plain"
+)
+
+var feedParsers = map[FeedFormat]func(line string) (netip.Prefix, bool){
+	FeedFormatSpamhaus: parseSpamhausLine,
+	FeedFormatFireHOL:  parsePlainFeedLine,
+	FeedFormatET:       parsePlainFeedLine,
+	FeedFormatPlain:    parsePlainFeedLine,
+}
+
+type FeedSpec struct {
+	Name   string
+	Format FeedFormat
+	Source string
+}
+
+// ParseFeedSpec parses "name=format:source" or "name=source", the latter
+// using the plain one-per-line format. The source is a file path or URL.
+func ParseFeedSpec(s string) (FeedSpec, error) {
+	name, rest, ok := strings.Cut(strings.TrimSpace(s), "=")
+	name = strings.TrimSpace(name)
+	rest = strings.TrimSpace(rest)
+	if !ok || name == "" || rest == "" {
+		return FeedSpec{}, fmt.Errorf("bad feed %q, expected name=format:source", s)
+	}
+
+	spec := FeedSpec{Name: name, Format: FeedFormatPlain, Source: rest}
+	if format, source, ok := strings.Cut(rest, ":"); ok {
+		if _, known := feedParsers[FeedFormat(format)]; known {
+			spec.Format = FeedFormat(format)
+			spec.Source = source
+		}
+	}
+	if spec.Source == "" {
+		return FeedSpec{}, fmt.Errorf("bad feed %q: missing source", s)
+	}
+	return spec, nil
+}
+
+type BlocklistFeeds struct {
+	specs []FeedSpec
+
+	mu       sync.RWMutex
+	prefixes map[string][]netip.Prefix
+	trie     *prefixTrie[string]
+
+	loop refreshLoop
+}
+
+func NewBlocklistFeeds(specs []FeedSpec) *BlocklistFeeds {
+	return &BlocklistFeeds{
+		specs:    specs,
+		prefixes: map[string][]netip.Prefix{},
+		trie:     newPrefixTrie[string](),
+	}
+}
+
+// Start loads all feeds and reloads them every interval. A feed that fails
+// to load keeps its previous entries.
+func (f *BlocklistFeeds) Start(interval time.Duration) {
+	f.Refresh()
+	f.loop.start(interval, f.Refresh)
+}
+
+func (f *BlocklistFeeds) Close() error {
+	f.loop.stop()
+	return nil
+}
+
+func (f *BlocklistFeeds) Refresh() {
+	loaded := map[string][]netip.Prefix{}
+	for _, spec := range f.specs {
+		prefixes, err := loadFeed(spec)
+		if err != nil {
+			log.Printf("blocklist feed %s (%s): %v", spec.Name, spec.Source, err)
+			continue
+		}
+		loaded[spec.Name] = prefixes
+		log.Printf("blocklist feed %s refreshed: %d entries", spec.Name, len(prefixes))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for name, prefixes := range loaded {
+		f.prefixes[name] = prefixes
+	}
+
+	trie := newPrefixTrie[string]()
+	for name, prefixes := range f.prefixes {
+		for _, p := range prefixes {
+			trie.Insert(p, name)
+		}
+	}
+	f.trie = trie
+}
+
+func loadFeed(spec FeedSpec) ([]netip.Prefix, error) {
+	rc, err := openSource(spec.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return parseFeed(rc, feedParsers[spec.Format])
+}
+
+func parseFeed(r io.Reader, parse func(string) (netip.Prefix, bool)) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		if p, ok := parse(sc.Text()); ok {
+			out = append(out, p)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// parsePlainFeedLine handles one IP or CIDR per line with "#" or ";"
+// comments, which covers FireHOL netsets and the ET compromised list.
+func parsePlainFeedLine(line string) (netip.Prefix, bool) {
+	if i := strings.IndexAny(line, "#;"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return netip.Prefix{}, false
+	}
+	return parsePrefixOrAddr(fields[0])
+}
+
+// parseSpamhausLine handles both the classic DROP/EDROP text format
+// ("1.10.16.0/20 ; SBL256894") and the newer JSON-lines format.
+func parseSpamhausLine(line string) (netip.Prefix, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var rec struct {
+			Cidr string `json:"cidr"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Cidr == "" {
+			return netip.Prefix{}, false
+		}
+		return parsePrefixOrAddr(rec.Cidr)
+	}
+	return parsePlainFeedLine(line)
+}
+
+func parsePrefixOrAddr(s string) (netip.Prefix, bool) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// Match returns the sorted names of all feeds listing addr.
+func (f *BlocklistFeeds) Match(addr netip.Addr) []string {
+	f.mu.RLock()
+	names := f.trie.Lookup(addr)
+	f.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	out := []string{}
+	for _, n := range names {
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (f *BlocklistFeeds) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+
+	out.Risk.Lists = f.Match(addr)
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+)
+
+// ClientIPInfo is the client address of a request and how it was derived.
+type ClientIPInfo struct {
+	// Addr is the client address, unmapped for IPv4.
+	Addr netip.Addr
+	// Peer is the connection's peer, or the source of its PROXY protocol
+	// header.
+	Peer netip.Addr
+	// Header is the forwarding header Addr was taken from, empty if it is
+	// the peer.
+	Header string
+	// Hop is the position of Addr in Header's chain counted from the
+	// right, or 0 for single-address headers.
+	Hop int
+	// Preset is the trusted proxy preset the peer belongs to, if any.
+	Preset string
+	// Suspicious are the Suspicious* reasons noticed while resolving Addr.
+	// DebugClientIP checks more thoroughly.
+	Suspicious []string
+}
+
+type clientIPContextKey struct{}
+
+// ClientIPResolver resolves the client address of every request once and
+// stores it in the request context, where ClientIPFromContext and
+// ClientIPInfoFromContext read it.
+func ClientIPResolver(c *LookupClient) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPContextKey{}, c.ResolveClientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIPFromContext returns the client address resolved by
+// ClientIPResolver.
+func ClientIPFromContext(ctx context.Context) (netip.Addr, bool) {
+	info, ok := ClientIPInfoFromContext(ctx)
+	if !ok || !info.Addr.IsValid() {
+		return netip.Addr{}, false
+	}
+	return info.Addr, true
+}
+
+// ClientIPInfoFromContext returns the client address resolved by
+// ClientIPResolver along with how it was derived.
+func ClientIPInfoFromContext(ctx context.Context) (ClientIPInfo, bool) {
+	info, ok := ctx.Value(clientIPContextKey{}).(ClientIPInfo)
+	return info, ok
+}
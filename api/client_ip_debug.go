@@ -0,0 +1,242 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Reasons a client address derivation is flagged as suspicious.
+const (
+	SuspiciousUntrustedHeaders = "untrusted_peer_headers"
+	SuspiciousPrivateForwarded = "private_forwarded_address"
+	SuspiciousHeadersDisagree  = "headers_disagree"
+	SuspiciousMalformedHeader  = "malformed_header"
+	SuspiciousBadSignature     = "invalid_signature"
+)
+
+// addressHeaders are the headers proxies commonly name the client in.
+var addressHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+	"Cf-Connecting-Ip",
+	"True-Client-Ip",
+	"Fastly-Client-Ip",
+	"X-Client-Ip",
+	"X-Cluster-Client-Ip",
+	"Cloudfront-Viewer-Address",
+}
+
+// forwardingInfoHeaders are reported, but don't name the client.
+var forwardingInfoHeaders = []string{"X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-Port", "Via"}
+
+// ClientIPDebug explains how the client address of a request was derived.
+// Peer is the connection's peer, or the source of its PROXY protocol header.
+type ClientIPDebug struct {
+	ClientIP      string              `json:"client_ip"`
+	Peer          string              `json:"peer"`
+	PeerTrusted   bool                `json:"peer_trusted"`
+	PinnedHeader  string              `json:"pinned_header,omitempty"`
+	Preset        string              `json:"preset,omitempty"`
+	ProxyProtocol *ProxyProtocolInfo  `json:"proxy_protocol,omitempty"`
+	Headers       map[string][]string `json:"headers"`
+	Source        string              `json:"source"`
+	Hop           int                 `json:"hop,omitempty"`
+	TrustedHops   int                 `json:"trusted_hops,omitempty"`
+	Suspicious    []ClientIPWarning   `json:"suspicious"`
+}
+
+type ClientIPWarning struct {
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+// DebugClientIP derives the client address of r like GetClientIP, and
+// reports every forwarding header along with anything that looks spoofed
+// or misconfigured.
+func (c *LookupClient) DebugClientIP(r *http.Request) ClientIPDebug {
+	info := c.clientIPInfo(r)
+
+	out := ClientIPDebug{
+		Headers:     map[string][]string{},
+		Source:      "peer",
+		TrustedHops: c.TrustedHops,
+		Suspicious:  []ClientIPWarning{},
+	}
+	out.ProxyProtocol, _ = ProxyProtocolFromContext(r.Context())
+	if !info.Peer.IsValid() {
+		return out
+	}
+
+	peer := net.IP(info.Peer.AsSlice())
+	out.ClientIP = info.Addr.String()
+	out.Peer = info.Peer.String()
+	out.PeerTrusted = c.isTrustedProxy(peer)
+	out.Preset = info.Preset
+	if p, ok := c.proxyHeaderFor(peer); ok {
+		out.PinnedHeader = p.Header
+	}
+	if info.Header != "" {
+		out.Source = info.Header
+		out.Hop = info.Hop
+	}
+
+	headers := c.addressHeaders()
+	for _, h := range append(headers, forwardingInfoHeaders...) {
+		if values := r.Header.Values(h); len(values) > 0 {
+			out.Headers[h] = values
+		}
+	}
+
+	warn := func(reason, format string, args ...any) {
+		out.Suspicious = append(out.Suspicious, ClientIPWarning{Reason: reason, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if c.SignedIPs != nil {
+		for _, h := range []string{SignedClientIPHeader, SignedTimestampHeader, SignedSignatureHeader} {
+			if values := r.Header.Values(h); len(values) > 0 {
+				out.Headers[h] = values
+			}
+		}
+		if _, err := c.SignedIPs.Verify(r); err != nil && !errors.Is(err, ErrSignatureMissing) {
+			warn(SuspiciousBadSignature, "%s from peer %s: %v", SignedClientIPHeader, out.Peer, err)
+		}
+	}
+
+	var present []string
+	for _, h := range headers {
+		if len(r.Header.Values(h)) > 0 {
+			present = append(present, h)
+		}
+	}
+
+	// A signed address is trusted from any peer.
+	if !out.PeerTrusted && info.Header != SignedClientIPHeader && len(present) > 0 {
+		warn(SuspiciousUntrustedHeaders, "peer %s is not a trusted proxy but sent %s", out.Peer, strings.Join(present, ", "))
+	}
+
+	var private []string
+	for _, entry := range c.forwardedEntries(r) {
+		addr, ok := netIPToNetipAddr(c.parseIP(entry))
+		if !ok || c.isTrustedProxy(addr.AsSlice()) {
+			continue
+		}
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+			private = append(private, addr.String())
+		}
+	}
+	if len(private) > 0 {
+		warn(SuspiciousPrivateForwarded, "forwarded chain contains non-public addresses of untrusted hosts: %s", strings.Join(private, ", "))
+	}
+
+	claims, malformed := c.headerClaims(r)
+	for _, h := range malformed {
+		warn(SuspiciousMalformedHeader, "%s names no usable address: %q", h, strings.Join(r.Header.Values(h), ", "))
+	}
+	if len(claims) > 1 {
+		var parts []string
+		for ip, hs := range claims {
+			parts = append(parts, strings.Join(hs, ", ")+": "+ip)
+		}
+		sort.Strings(parts)
+		warn(SuspiciousHeadersDisagree, "headers name different clients: %s", strings.Join(parts, "; "))
+	}
+
+	return out
+}
+
+// addressHeaders returns the well-known client address headers and any
+// configured for trusted proxies.
+func (c *LookupClient) addressHeaders() []string {
+	out := append([]string(nil), addressHeaders...)
+	seen := map[string]bool{}
+	for _, h := range out {
+		seen[h] = true
+	}
+	for _, p := range c.ProxyHeaders {
+		if !seen[p.Header] {
+			seen[p.Header] = true
+			out = append(out, p.Header)
+		}
+	}
+	return out
+}
+
+// headerClaims groups the address headers present in r by the client they
+// name, and returns those naming no usable address separately.
+func (c *LookupClient) headerClaims(r *http.Request) (map[string][]string, []string) {
+	claims := map[string][]string{}
+	var malformed []string
+	for _, h := range c.addressHeaders() {
+		if len(r.Header.Values(h)) == 0 {
+			continue
+		}
+		ip := c.headerClaim(r, h)
+		if ip == nil {
+			malformed = append(malformed, h)
+			continue
+		}
+		claims[ip.String()] = append(claims[ip.String()], h)
+	}
+	return claims, malformed
+}
+
+// headerClaim returns the client a header names, following the same chain
+// rules as GetClientIP.
+func (c *LookupClient) headerClaim(r *http.Request, header string) net.IP {
+	if header == "Cloudfront-Viewer-Address" {
+		// "<ip>:<port>", with IPv6 addresses unbracketed.
+		v := r.Header.Get(header)
+		if i := strings.LastIndexByte(v, ':'); i >= 0 {
+			v = v[:i]
+		}
+		return c.parseIP(v)
+	}
+	ip, _ := c.clientIPFromHeader(r, header)
+	return ip
+}
+
+// forwardedEntries returns the addresses of the X-Forwarded-For and
+// Forwarded chains.
+func (c *LookupClient) forwardedEntries(r *http.Request) []string {
+	var out []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(v, ",") {
+			if addr, ok := ParseForwardedNode(strings.TrimSpace(entry)); ok {
+				out = append(out, addr.String())
+			}
+		}
+	}
+	if elems, err := ParseForwarded(r.Header.Values("Forwarded")); err == nil {
+		for _, e := range elems {
+			if addr, ok := ParseForwardedNode(e.For); ok {
+				out = append(out, addr.String())
+			}
+		}
+	}
+	return out
+}
+
+// ClientIPAuditor counts requests whose client address derivation looks
+// suspicious, by reason. It reads what ClientIPResolver noticed, so it
+// costs next to nothing per request.
+func ClientIPAuditor(c *LookupClient, counter *CounterVec) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" {
+				info := c.clientIPInfo(r)
+				for i, reason := range info.Suspicious {
+					if !slices.Contains(info.Suspicious[:i], reason) {
+						counter.Inc(reason)
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIPAuditor(t *testing.T) {
+	signed, err := NewSignedClientIP([]string{"0123456789abcdef"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &LookupClient{TrustedProxies: mustCIDRs(t, "10.0.0.0/8"), SignedIPs: signed}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    []string
+	}{
+		{name: "clean", peer: "10.0.0.1", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}},
+		{name: "untrusted peer", peer: "198.51.100.1", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, want: []string{SuspiciousUntrustedHeaders}},
+		{name: "private client", peer: "10.0.0.1", headers: map[string]string{"X-Real-Ip": "192.168.1.20"}, want: []string{SuspiciousPrivateForwarded}},
+		{name: "malformed", peer: "10.0.0.1", headers: map[string]string{"X-Real-Ip": "nope", "X-Forwarded-For": "203.0.113.7"}, want: []string{SuspiciousMalformedHeader}},
+		{name: "disagree", peer: "10.0.0.1", headers: map[string]string{"X-Real-Ip": "203.0.113.7", "X-Forwarded-For": "198.51.100.9"}, want: []string{SuspiciousHeadersDisagree}},
+		{name: "untrusted disagree", peer: "198.51.100.1", headers: map[string]string{"X-Real-Ip": "203.0.113.7", "X-Forwarded-For": "198.51.100.9"}, want: []string{SuspiciousUntrustedHeaders, SuspiciousHeadersDisagree}},
+		{name: "bad signature", peer: "198.51.100.1", headers: map[string]string{
+			SignedClientIPHeader: "203.0.113.7", SignedTimestampHeader: "1", SignedSignatureHeader: "00",
+		}, want: []string{SuspiciousBadSignature}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := NewCounterVec("test", "", "reason")
+			h := ClientIPResolver(c)(ClientIPAuditor(c, counter)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+			r := httptest.NewRequest(http.MethodGet, "/own", nil)
+			r.RemoteAddr = tt.peer + ":5555"
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			for _, reason := range []string{SuspiciousUntrustedHeaders, SuspiciousPrivateForwarded, SuspiciousHeadersDisagree, SuspiciousMalformedHeader, SuspiciousBadSignature} {
+				want := uint64(0)
+				for _, w := range tt.want {
+					if w == reason {
+						want = 1
+					}
+				}
+				if got := counter.Get(reason); got != want {
+					t.Errorf("%s = %d, want %d", reason, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DnsblDefaultTimeout = time.Second
+	dnsblCacheSize      = 10000
+)
+
+type dnsblZone struct {
+	list    string
+	reasons map[string]string
+}
+
+// knownDnsblZones decodes the return codes of common DNSBLs. Codes of
+// unknown zones are reported as-is.
+var knownDnsblZones = map[string]dnsblZone{
+	"zen.spamhaus.org": {
+		list: "Spamhaus ZEN",
+		reasons: map[string]string{
+			"127.0.0.2":  "SBL",
+			"127.0.0.3":  "SBL CSS",
+			"127.0.0.4":  "XBL",
+			"127.0.0.5":  "XBL",
+			"127.0.0.6":  "XBL",
+			"127.0.0.7":  "XBL",
+			"127.0.0.9":  "SBL DROP",
+			"127.0.0.10": "PBL ISP",
+			"127.0.0.11": "PBL Spamhaus",
+		},
+	},
+	"sbl.spamhaus.org": {
+		list:    "Spamhaus SBL",
+		reasons: map[string]string{"127.0.0.2": "SBL", "127.0.0.3": "SBL CSS", "127.0.0.9": "SBL DROP"},
+	},
+	"xbl.spamhaus.org": {
+		list:    "Spamhaus XBL",
+		reasons: map[string]string{"127.0.0.4": "XBL", "127.0.0.5": "XBL", "127.0.0.6": "XBL", "127.0.0.7": "XBL"},
+	},
+	"pbl.spamhaus.org": {
+		list:    "Spamhaus PBL",
+		reasons: map[string]string{"127.0.0.10": "PBL ISP", "127.0.0.11": "PBL Spamhaus"},
+	},
+	"bl.spamcop.net": {
+		list:    "SpamCop",
+		reasons: map[string]string{"127.0.0.2": "Spam source"},
+	},
+	"b.barracudacentral.org": {
+		list:    "Barracuda",
+		reasons: map[string]string{"127.0.0.2": "Poor reputation"},
+	},
+	"dnsbl-1.uceprotect.net": {
+		list:    "UCEPROTECT Level 1",
+		reasons: map[string]string{"127.0.0.2": "Spam source"},
+	},
+	"psbl.surriel.com": {
+		list:    "PSBL",
+		reasons: map[string]string{"127.0.0.2": "Spam source"},
+	},
+}
+
+type DnsblOptions struct {
+	Zones    []string
+	Resolver string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+}
+
+type Dnsbl struct {
+	zones    []string
+	resolver *net.Resolver
+	timeout  time.Duration
+	cache    *ttlCache[netip.Addr, []DnsblListing]
+}
+
+func NewDnsbl(opts DnsblOptions) *Dnsbl {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DnsblDefaultTimeout
+	}
+
+	resolver := net.DefaultResolver
+	if opts.Resolver != "" {
+		addr := opts.Resolver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	}
+
+	zones := make([]string, 0, len(opts.Zones))
+	for _, z := range opts.Zones {
+		z = strings.Trim(strings.ToLower(strings.TrimSpace(z)), ".")
+		if z != "" {
+			zones = append(zones, z)
+		}
+	}
+
+	return &Dnsbl{
+		zones:    zones,
+		resolver: resolver,
+		timeout:  opts.Timeout,
+		cache:    newTTLCache[netip.Addr, []DnsblListing](opts.CacheTTL, dnsblCacheSize),
+	}
+}
+
+// dnsblQueryName builds the DNSBL query name of addr in zone: reversed
+// octets for IPv4 and reversed nibbles for IPv6.
+func dnsblQueryName(addr netip.Addr, zone string) string {
+	addr = addr.Unmap()
+	b := addr.AsSlice()
+
+	var sb strings.Builder
+	if addr.Is4() {
+		for i := len(b) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "%d.", b[i])
+		}
+	} else {
+		const hex = "0123456789abcdef"
+		for i := len(b) - 1; i >= 0; i-- {
+			sb.WriteByte(hex[b[i]&0x0f])
+			sb.WriteByte('.')
+			sb.WriteByte(hex[b[i]>>4])
+			sb.WriteByte('.')
+		}
+	}
+	sb.WriteString(zone)
+	return sb.String()
+}
+
+// Check returns the listings of addr in all zones and whether they were
+// checked, cached or are unavailable. Results are cached only if every zone
+// answered, so a failing zone can't hide a listing for the cache's TTL.
+func (d *Dnsbl) Check(ctx context.Context, addr netip.Addr) ([]DnsblListing, DataStatus) {
+	if listings, ok := d.cache.Get(addr); ok {
+		return listings, DataCached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		out    = []DnsblListing{}
+		failed bool
+	)
+
+	for _, zone := range d.zones {
+		wg.Add(1)
+		go func(zone string) {
+			defer wg.Done()
+
+			listing, listed, err := d.query(ctx, addr, zone)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("dnsbl %s: %v", zone, err)
+				failed = true
+			}
+			if listed {
+				out = append(out, listing)
+			}
+		}(zone)
+	}
+	wg.Wait()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Zone < out[j].Zone })
+
+	if failed {
+		return out, DataUnavailable
+	}
+	d.cache.Set(addr, out)
+	return out, DataChecked
+}
+
+// query asks zone about addr. Anything but a listing or NXDOMAIN is an
+// error, as the address may be listed all the same.
+func (d *Dnsbl) query(ctx context.Context, addr netip.Addr, zone string) (DnsblListing, bool, error) {
+	addrs, err := d.resolver.LookupHost(ctx, dnsblQueryName(addr, zone)+".")
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return DnsblListing{}, false, nil
+		}
+		return DnsblListing{}, false, err
+	}
+
+	known, isKnown := knownDnsblZones[zone]
+	listing := DnsblListing{Zone: zone, List: zone, Codes: []string{}, Reasons: []string{}}
+	if isKnown {
+		listing.List = known.list
+	}
+
+	for _, a := range addrs {
+		code, err := netip.ParseAddr(a)
+		if err != nil || !code.Is4() || code.As4()[0] != 127 {
+			continue
+		}
+		// 127.255.255.x are error codes, e.g. queries through public resolvers.
+		if strings.HasPrefix(a, "127.255.255.") {
+			return DnsblListing{}, false, fmt.Errorf("refused query with %s", a)
+		}
+
+		listing.Codes = append(listing.Codes, a)
+		if reason, ok := known.reasons[a]; ok {
+			listing.Reasons = appendUnique(listing.Reasons, reason)
+		}
+	}
+
+	if len(listing.Codes) == 0 {
+		return DnsblListing{}, false, nil
+	}
+	sort.Strings(listing.Codes)
+	return listing, true, nil
+}
+
+func appendUnique(items []string, s string) []string {
+	for _, it := range items {
+		if it == s {
+			return items
+		}
+	}
+	return append(items, s)
+}
+
+func (d *Dnsbl) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+	// DNSBLs only list public addresses.
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return nil
+	}
+
+	out.Risk.Dnsbl, out.Risk.DnsblStatus = d.Check(context.Background(), addr)
+	if out.Risk.DnsblStatus == DataUnavailable {
+		return errors.New("dnsbl: not every zone answered")
+	}
+	return nil
+}
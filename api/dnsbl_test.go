@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsTypeA         = 1
+)
+
+// dnsStub is an in-process DNS server answering A queries from a fixed
+// table, and SERVFAIL for zones marked as broken.
+type dnsStub struct {
+	conn    net.PacketConn
+	answers map[string][]string
+
+	mu      sync.Mutex
+	queries map[string]int
+	broken  map[string]bool
+}
+
+func newDnsStub(t *testing.T, answers map[string][]string) *dnsStub {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnsStub{conn: conn, answers: answers, queries: map[string]int{}, broken: map[string]bool{}}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *dnsStub) addr() string { return s.conn.LocalAddr().String() }
+
+func (s *dnsStub) setBroken(zone string, broken bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broken[zone] = broken
+}
+
+// count returns the A queries for names in zone.
+func (s *dnsStub) count(zone string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[zone]
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			_, _ = s.conn.WriteTo(resp, from)
+		}
+	}
+}
+
+func (s *dnsStub) answer(req []byte) []byte {
+	if len(req) < 12 {
+		return nil
+	}
+
+	// Read the single question.
+	var labels []string
+	i := 12
+	for i < len(req) && req[i] != 0 {
+		l := int(req[i])
+		if i+1+l > len(req) {
+			return nil
+		}
+		labels = append(labels, string(req[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(req) {
+		return nil
+	}
+	question := req[12 : i+5]
+	qtype := binary.BigEndian.Uint16(req[i+1:])
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	var zone string
+	for z := range s.answers {
+		if strings.HasSuffix(name, "."+z) {
+			zone = z
+		}
+	}
+
+	s.mu.Lock()
+	broken := s.broken[zone]
+	if qtype == dnsTypeA && zone != "" {
+		s.queries[zone]++
+	}
+	s.mu.Unlock()
+
+	rcode := 0
+	var codes []string
+	switch {
+	case zone == "":
+		rcode = dnsRcodeNXDomain
+	case broken:
+		rcode = dnsRcodeServFail
+	default:
+		codes = s.answers[zone]
+		if len(codes) == 0 {
+			rcode = dnsRcodeNXDomain
+		}
+	}
+	if qtype != dnsTypeA {
+		codes = nil
+	}
+
+	resp := binary.BigEndian.AppendUint16(nil, binary.BigEndian.Uint16(req))
+	resp = binary.BigEndian.AppendUint16(resp, 0x8180|uint16(rcode))
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(codes)))
+	resp = binary.BigEndian.AppendUint32(resp, 0)
+	resp = append(resp, question...)
+	for _, c := range codes {
+		a := netip.MustParseAddr(c).As4()
+		resp = append(resp, 0xc0, 12)
+		resp = binary.BigEndian.AppendUint16(resp, dnsTypeA)
+		resp = binary.BigEndian.AppendUint16(resp, 1)
+		resp = binary.BigEndian.AppendUint32(resp, 60)
+		resp = binary.BigEndian.AppendUint16(resp, 4)
+		resp = append(resp, a[:]...)
+	}
+	return resp
+}
+
+func TestDnsblQueryName(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "203.0.113.7", want: "7.113.0.203.zen.spamhaus.org"},
+		{addr: "::ffff:203.0.113.7", want: "7.113.0.203.zen.spamhaus.org"},
+		{addr: "2001:db8::1", want: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org"},
+	}
+	for _, tt := range tests {
+		if got := dnsblQueryName(netip.MustParseAddr(tt.addr), "zen.spamhaus.org"); got != tt.want {
+			t.Errorf("dnsblQueryName(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestDnsblCheck(t *testing.T) {
+	stub := newDnsStub(t, map[string][]string{
+		"zen.spamhaus.org": {"127.0.0.4", "127.0.0.10"},
+		"clean.test":       nil,
+	})
+	d := NewDnsbl(DnsblOptions{Zones: []string{"zen.spamhaus.org", "clean.test"}, Resolver: stub.addr(), Timeout: 2 * time.Second, CacheTTL: time.Minute})
+
+	var res LookupResult
+	if err := d.Enrich(net.ParseIP("203.0.113.7"), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Risk.DnsblStatus != DataChecked {
+		t.Errorf("status = %s, want checked", res.Risk.DnsblStatus)
+	}
+	if len(res.Risk.Dnsbl) != 1 {
+		t.Fatalf("listings = %+v, want one", res.Risk.Dnsbl)
+	}
+	l := res.Risk.Dnsbl[0]
+	if l.Zone != "zen.spamhaus.org" || l.List != "Spamhaus ZEN" {
+		t.Errorf("listing = %+v", l)
+	}
+	if !slices.Equal(l.Codes, []string{"127.0.0.10", "127.0.0.4"}) || !slices.Equal(slices.Sorted(slices.Values(l.Reasons)), []string{"PBL ISP", "XBL"}) {
+		t.Errorf("codes = %v, reasons = %v", l.Codes, l.Reasons)
+	}
+
+	// Asked again, the answer comes from the cache.
+	res = LookupResult{}
+	_ = d.Enrich(net.ParseIP("203.0.113.7"), &res)
+	if res.Risk.DnsblStatus != DataCached || len(res.Risk.Dnsbl) != 1 {
+		t.Errorf("status = %s, listings = %v, want the cached listing", res.Risk.DnsblStatus, res.Risk.Dnsbl)
+	}
+	if n := stub.count("zen.spamhaus.org"); n != 1 {
+		t.Errorf("queries = %d, want 1", n)
+	}
+}
+
+func TestDnsblFailureNotCached(t *testing.T) {
+	stub := newDnsStub(t, map[string][]string{
+		"zen.spamhaus.org": {"127.0.0.2"},
+		"clean.test":       nil,
+	})
+	d := NewDnsbl(DnsblOptions{Zones: []string{"zen.spamhaus.org", "clean.test"}, Resolver: stub.addr(), Timeout: 2 * time.Second, CacheTTL: time.Minute})
+	addr := netip.MustParseAddr("203.0.113.7")
+
+	stub.setBroken("zen.spamhaus.org", true)
+	listings, status := d.Check(context.Background(), addr)
+	if status != DataUnavailable || len(listings) != 0 {
+		t.Errorf("status = %s, listings = %v, want unavailable and none", status, listings)
+	}
+
+	// Once the zone answers again, the listing shows up right away.
+	stub.setBroken("zen.spamhaus.org", false)
+	listings, status = d.Check(context.Background(), addr)
+	if status != DataChecked || len(listings) != 1 {
+		t.Errorf("status = %s, listings = %v, want the listing", status, listings)
+	}
+}
+
+func TestDnsblSkipsNonGlobal(t *testing.T) {
+	stub := newDnsStub(t, map[string][]string{"zen.spamhaus.org": {"127.0.0.2"}})
+	d := NewDnsbl(DnsblOptions{Zones: []string{"zen.spamhaus.org"}, Resolver: stub.addr()})
+
+	for _, ip := range []string{"10.0.0.1", "127.0.0.1", "169.254.1.1", "::1", "fd00::1", "0.0.0.0"} {
+		var res LookupResult
+		_ = d.Enrich(net.ParseIP(ip), &res)
+		if res.Risk.Dnsbl != nil || res.Risk.DnsblStatus != "" {
+			t.Errorf("%s: queried, got %v", ip, res.Risk.Dnsbl)
+		}
+	}
+	if n := stub.count("zen.spamhaus.org"); n != 0 {
+		t.Errorf("queries = %d, want 0", n)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/netip"
+	"time"
+)
+
+const forwardAuthCacheSize = 50000
+
+// forwardAuthDecision is what /forward-auth answers for a client, as
+// response headers.
+type forwardAuthDecision struct {
+	decision PolicyDecision
+	rule     string
+	headers  map[string]string
+}
+
+// ForwardAuthCache remembers forward-auth decisions per client IP, as a
+// proxy asks for every request it forwards, usually for a few clients in
+// quick succession.
+type ForwardAuthCache struct {
+	cache *ttlCache[netip.Addr, forwardAuthDecision]
+}
+
+func NewForwardAuthCache(ttl time.Duration) *ForwardAuthCache {
+	return &ForwardAuthCache{cache: newTTLCache[netip.Addr, forwardAuthDecision](ttl, forwardAuthCacheSize)}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestForwardAuthStatus(t *testing.T) {
+	tests := []struct {
+		decision PolicyDecision
+		status   int
+		want     int
+	}{
+		{decision: PolicyAllow, want: http.StatusOK},
+		{decision: PolicyChallenge, want: http.StatusUnauthorized},
+		{decision: PolicyChallenge, status: http.StatusTooManyRequests, want: http.StatusTooManyRequests},
+		{decision: PolicyDeny, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.decision), func(t *testing.T) {
+			// A cached decision is answered without a lookup.
+			s := &Server{LookupClient: &LookupClient{}, AuthCache: NewForwardAuthCache(time.Minute), ForwardAuthChallengeStatus: tt.status}
+			s.AuthCache.cache.Set(netip.MustParseAddr("203.0.113.7"), forwardAuthDecision{
+				decision: tt.decision,
+				headers:  map[string]string{"X-Policy-Decision": string(tt.decision)},
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/forward-auth", nil)
+			r.RemoteAddr = "203.0.113.7:5555"
+			w := httptest.NewRecorder()
+			s.ForwardAuth(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("X-Policy-Decision"); got != string(tt.decision) {
+				t.Errorf("X-Policy-Decision = %q", got)
+			}
+			if hasChallenge := w.Header().Get("WWW-Authenticate") != ""; hasChallenge != (w.Code == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q with status %d", w.Header().Get("WWW-Authenticate"), w.Code)
+			}
+		})
+	}
+}
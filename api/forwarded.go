@@ -0,0 +1,268 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ForwardedElement is one hop of an RFC 7239 Forwarded header: the proxy
+// that received the request from For, on its interface By, over Proto and
+// for Host. Node identifiers are kept as sent, including ports and the
+// obfuscated forms "unknown" and "_hidden".
+type ForwardedElement struct {
+	For   string `json:"for,omitempty"`
+	By    string `json:"by,omitempty"`
+	Proto string `json:"proto,omitempty"`
+	Host  string `json:"host,omitempty"`
+}
+
+// ParseForwarded parses the Forwarded header values of a request into its
+// elements, ordered from the client to the nearest proxy. Multiple header
+// lines are treated as one comma-separated list.
+func ParseForwarded(values []string) ([]ForwardedElement, error) {
+	p := forwardedParser{s: strings.Join(values, ",")}
+
+	var out []ForwardedElement
+	for {
+		p.skipSpace()
+		if p.done() {
+			return out, nil
+		}
+
+		elem, pairs, err := p.element()
+		if err != nil {
+			return nil, err
+		}
+		// Empty list elements are allowed, e.g. "for=a, , for=b".
+		if pairs > 0 {
+			out = append(out, elem)
+		}
+
+		p.skipSpace()
+		if p.done() {
+			return out, nil
+		}
+		if p.s[p.pos] != ',' {
+			return nil, fmt.Errorf("forwarded: unexpected %q at %d", p.s[p.pos], p.pos)
+		}
+		p.pos++
+	}
+}
+
+type forwardedParser struct {
+	s   string
+	pos int
+}
+
+func (p *forwardedParser) done() bool { return p.pos >= len(p.s) }
+
+func (p *forwardedParser) skipSpace() {
+	for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// element parses pairs separated by ";" up to the next "," outside of a
+// quoted string, and returns the number of pairs.
+func (p *forwardedParser) element() (ForwardedElement, int, error) {
+	var elem ForwardedElement
+	seen := map[string]bool{}
+
+	for {
+		p.skipSpace()
+		if p.done() || p.s[p.pos] == ',' {
+			return elem, len(seen), nil
+		}
+		if p.s[p.pos] == ';' {
+			p.pos++
+			continue
+		}
+
+		name := strings.ToLower(p.token())
+		if name == "" {
+			return elem, 0, fmt.Errorf("forwarded: expected parameter name at %d", p.pos)
+		}
+		if p.done() || p.s[p.pos] != '=' {
+			return elem, 0, fmt.Errorf("forwarded: expected = after %q", name)
+		}
+		p.pos++
+
+		value, err := p.value()
+		if err != nil {
+			return elem, 0, err
+		}
+
+		// Each parameter may occur only once per element.
+		if seen[name] {
+			return elem, 0, fmt.Errorf("forwarded: duplicate parameter %q", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "for":
+			elem.For = value
+		case "by":
+			elem.By = value
+		case "proto":
+			elem.Proto = strings.ToLower(value)
+		case "host":
+			elem.Host = value
+		}
+		// Extension parameters are ignored.
+
+		p.skipSpace()
+		if !p.done() && p.s[p.pos] != ';' && p.s[p.pos] != ',' {
+			return elem, 0, fmt.Errorf("forwarded: unexpected %q at %d", p.s[p.pos], p.pos)
+		}
+	}
+}
+
+func (p *forwardedParser) value() (string, error) {
+	if !p.done() && p.s[p.pos] == '"' {
+		return p.quoted()
+	}
+	v := p.token()
+	if v == "" {
+		return "", fmt.Errorf("forwarded: expected value at %d", p.pos)
+	}
+	return v, nil
+}
+
+// token reads an RFC 7230 token.
+func (p *forwardedParser) token() string {
+	start := p.pos
+	for !p.done() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// quoted reads a quoted string, resolving backslash escapes.
+func (p *forwardedParser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for !p.done() {
+		switch ch := p.s[p.pos]; ch {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if p.pos+1 >= len(p.s) {
+				return "", fmt.Errorf("forwarded: unterminated escape at %d", p.pos)
+			}
+			b.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(ch)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("forwarded: unterminated quoted string at %d", start)
+}
+
+func isTokenChar(ch byte) bool {
+	switch {
+	case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", ch) >= 0
+}
+
+// ParseForwardedNode parses a node identifier such as "192.0.2.43",
+// "[2001:db8:cafe::17]:4711" or "_hidden". It returns the address, or false
+// for the obfuscated and "unknown" identifiers, which name no address.
+func ParseForwardedNode(node string) (netip.Addr, bool) {
+	name := node
+	if strings.HasPrefix(node, "[") {
+		end := strings.IndexByte(node, ']')
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+		name = node[1:end]
+		if rest := node[end+1:]; rest != "" && !validForwardedPort(rest) {
+			return netip.Addr{}, false
+		}
+	} else if i := strings.IndexByte(node, ':'); i >= 0 {
+		// IPv6 without brackets is invalid, but common enough to accept.
+		if strings.Count(node, ":") > 1 {
+			name = node
+		} else {
+			name = node[:i]
+			if !validForwardedPort(node[i:]) {
+				return netip.Addr{}, false
+			}
+		}
+	}
+
+	if name == "unknown" || strings.HasPrefix(name, "_") {
+		return netip.Addr{}, false
+	}
+
+	addr, err := netip.ParseAddr(name)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// validForwardedPort checks ":<port>", where the port may be obfuscated.
+func validForwardedPort(s string) bool {
+	port, ok := strings.CutPrefix(s, ":")
+	if !ok || port == "" {
+		return false
+	}
+	if strings.HasPrefix(port, "_") {
+		return true
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// ForwardedChain returns the elements of the request's Forwarded header if
+// the peer is trusted to set it, and nil otherwise.
+func (c *LookupClient) ForwardedChain(r *http.Request) []ForwardedElement {
+	remoteIP := c.remoteAddrIP(r.RemoteAddr)
+	if remoteIP == nil {
+		return nil
+	}
+	if p, ok := c.proxyHeaderFor(remoteIP); ok {
+		if p.Header != "Forwarded" {
+			return nil
+		}
+	} else if !c.isTrustedProxy(remoteIP) {
+		return nil
+	}
+
+	elems, err := ParseForwarded(r.Header.Values("Forwarded"))
+	if err != nil {
+		return nil
+	}
+	return elems
+}
+
+// clientIPFromForwarded walks the for= nodes of a Forwarded header like an
+// X-Forwarded-For chain.
+func (c *LookupClient) clientIPFromForwarded(values []string) (net.IP, int) {
+	elems, err := ParseForwarded(values)
+	if err != nil || len(elems) == 0 {
+		return nil, 0
+	}
+
+	chain := make([]string, 0, len(elems))
+	for _, e := range elems {
+		addr, ok := ParseForwardedNode(e.For)
+		if !ok {
+			// Leave a placeholder that stops the walk.
+			chain = append(chain, "")
+			continue
+		}
+		chain = append(chain, addr.String())
+	}
+	return c.clientFromChain(chain)
+}
@@ -36,15 +36,25 @@ func (a *AsnReader) Enrich(ip net.IP, out *LookupResult) error {
 		return nil
 	}
 
+	result := a.db.Lookup(addr)
+	if !result.Found() {
+		out.ISP.Status = DataNotFound
+		return nil
+	}
+
 	var rec AsnRecord
-	if err := a.db.Lookup(addr).Decode(&rec); err != nil {
+	if err := result.Decode(&rec); err != nil {
+		out.ISP.Status = DataUnavailable
 		return err
 	}
 
 	if rec.ASN == 0 && rec.Org == "" {
+		out.ISP.Status = DataNotFound
 		return nil
 	}
 
+	out.ISP.Status = DataChecked
+
 	out.ISP.ASN = fmt.Sprintf("AS%d", rec.ASN)
 	out.ISP.Org = rec.Org
 	out.ISP.ISP = rec.Org
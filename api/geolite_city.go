@@ -58,11 +58,20 @@ func (c *CityReader) Enrich(ip net.IP, out *LookupResult) error {
 		return nil
 	}
 
+	result := c.db.Lookup(addr)
+	if !result.Found() {
+		out.Location.Status = DataNotFound
+		return nil
+	}
+
 	var rec cityRecord
-	if err := c.db.Lookup(addr).Decode(&rec); err != nil {
+	if err := result.Decode(&rec); err != nil {
+		out.Location.Status = DataUnavailable
 		return err
 	}
 
+	out.Location.Status = DataChecked
+
 	out.Location.Country = rec.Country.Names["en"]
 	out.Location.CountryCode = rec.Country.ISOCode
 	out.Location.City = rec.City.Names["en"]
@@ -73,8 +82,12 @@ func (c *CityReader) Enrich(ip net.IP, out *LookupResult) error {
 		}
 	}
 	out.Location.Zipcode = rec.Postal.Code
-	out.Location.Latitude = rec.Location.Latitude
-	out.Location.Longitude = rec.Location.Longitude
+	// Records with a country but no coordinates would otherwise put the
+	// address at 0,0.
+	if rec.Location.Latitude != 0 || rec.Location.Longitude != 0 {
+		out.Location.Latitude = ptr(rec.Location.Latitude)
+		out.Location.Longitude = ptr(rec.Location.Longitude)
+	}
 	out.Location.Timezone = rec.Location.TimeZone
 
 	if tz := rec.Location.TimeZone; tz != "" {
@@ -2,15 +2,39 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
+	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Server struct {
 	*LookupClient
+	Reporter     *AbuseIpDbReporter
+	BlockChecker *AbuseIpDbBlockChecker
+	Policy       *PolicyEngine
+	AuthPolicy   *PolicyEngine
+	Sightings    *SightingsStore
+	Lists        *ManagedLists
+	// AuthCache caches /forward-auth decisions per client IP, if set.
+	// ForwardAuthChallengeStatus is returned for challenge decisions, 401
+	// if zero.
+	AuthCache                  *ForwardAuthCache
+	ForwardAuthChallengeStatus int
+	// SightingsApiKeys authorize /sightings/{ip} and risk.local in lookups,
+	// which reveal who uses this service.
+	SightingsApiKeys []string
+	// OwnV4Url and OwnV6Url are where /own/v4 and /own/v6 are reachable
+	// over that family only, e.g. on hostnames with only A or AAAA
+	// records. Empty means the same origin.
+	OwnV4Url string
+	OwnV6Url string
 }
 
 func (s *Server) GetHealth(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +62,59 @@ func (s *Server) GetOwnIPAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.lookupIPAll(w, r, ipStr)
+	res, ok := s.lookupIP(w, r, ipStr)
+	if !ok {
+		return
+	}
+	res.Forwarded = s.ForwardedChain(r)
+	res.ProxyProtocol, _ = ProxyProtocolFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// GetOwnDebug explains how the caller's address was derived.
+func (s *Server) GetOwnDebug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(s.DebugClientIP(r))
+}
+
+// OwnFamily serves /own/v4 and /own/v6: the caller's address if the request
+// arrived over that family, and 404 otherwise. The landing page calls them
+// cross-origin, on the family-specific hostnames.
+func (s *Server) OwnFamily(v6 bool, all bool) http.HandlerFunc {
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		addr := s.clientIPInfo(r).Addr
+		if !addr.IsValid() {
+			http.Error(w, "unable to determine client ip", http.StatusBadRequest)
+			return
+		}
+		if addr.Is6() != v6 {
+			http.Error(w, "request did not arrive over "+family, http.StatusNotFound)
+			return
+		}
+
+		if !all {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(addr.String()))
+			return
+		}
+
+		res, ok := s.lookupIP(w, r, addr.String())
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(res)
+	}
 }
 
 func (s *Server) LookupIPAll(w http.ResponseWriter, r *http.Request) {
@@ -48,36 +124,368 @@ func (s *Server) LookupIPAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.lookupIPAll(w, r, ipStr)
+	res, ok := s.lookupIP(w, r, ipStr)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(res)
 }
 
-func (s *Server) lookupIPAll(w http.ResponseWriter, r *http.Request, ipStr string) {
+// lookupIP looks up ipStr, or writes an error response and returns false.
+// Sightings are included for requests authorized to see them.
+func (s *Server) lookupIP(w http.ResponseWriter, r *http.Request, ipStr string) (LookupResult, bool) {
 	ipNet := net.ParseIP(ipStr)
 	if ipNet == nil {
 		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return LookupResult{}, false
+	}
+
+	res, err := s.Lookup(ipNet)
+	if err != nil {
+		writeInternalError(w, "lookup "+ipStr, err)
+		return LookupResult{}, false
+	}
+
+	if s.Sightings != nil && validApiKey(requestApiKey(r), s.SightingsApiKeys) {
+		if addr, ok := netIPToNetipAddr(ipNet); ok {
+			if e, ok := s.Sightings.Get(addr); ok {
+				res.Risk.Local = &e
+			}
+		}
+	}
+	return res, true
+}
+
+func (s *Server) GetDecision(w http.ResponseWriter, r *http.Request) {
+	ipStr := chi.URLParam(r, "ip")
+	if ipStr == "" {
+		http.Error(w, "missing ip parameter", http.StatusBadRequest)
 		return
 	}
 
-	res := LookupResult{IP: ipNet.String()}
+	s.decide(w, ipStr, nil)
+}
+
+type decideRequest struct {
+	IP     string        `json:"ip"`
+	Result *LookupResult `json:"result"`
+}
 
-	if err := s.AsnReader.Enrich(ipNet, &res); err != nil {
-		http.Error(w, "asn lookup failed", http.StatusInternalServerError)
+func (s *Server) PostDecision(w http.ResponseWriter, r *http.Request) {
+	var req decideRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid decide body", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.CityReader.Enrich(ipNet, &res); err != nil {
-		http.Error(w, "city lookup failed", http.StatusInternalServerError)
+	if req.Result != nil {
+		if req.IP == "" {
+			req.IP = req.Result.IP
+		}
+		req.Result.IP = req.IP
+	}
+
+	s.decide(w, req.IP, req.Result)
+}
+
+// decide evaluates the policy against res, or against a fresh lookup of
+// ipStr if res is nil.
+func (s *Server) decide(w http.ResponseWriter, ipStr string, res *LookupResult) {
+	ipNet := net.ParseIP(ipStr)
+	if ipNet == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+
+	if res == nil {
+		lookup, err := s.Lookup(ipNet)
+		if err != nil {
+			writeInternalError(w, "decide "+ipStr, err)
+			return
+		}
+		res = &lookup
+	}
+	res.IP = ipNet.String()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(s.Policy.Decide(*res))
+}
+
+// ForwardAuth implements the forward authentication protocol of Caddy
+// (forward_auth), Traefik (ForwardAuth) and nginx (auth_request): 200 lets
+// the request through, anything else rejects it. An allow decision returns
+// 200, challenge ForwardAuthChallengeStatus and deny 403, so that the proxy
+// can tell a challenge from a rejection. The lookup is handed to the
+// upstream in X-* response headers.
+func (s *Server) ForwardAuth(w http.ResponseWriter, r *http.Request) {
+	addr := s.clientIPInfo(r).Addr
+	if !addr.IsValid() {
+		http.Error(w, "unable to determine client ip", http.StatusForbidden)
+		return
+	}
+
+	d, err := s.forwardAuthDecision(addr)
+	if err != nil {
+		writeInternalError(w, "forward-auth "+addr.String(), err)
+		return
+	}
+
+	h := w.Header()
+	for k, v := range d.headers {
+		h.Set(k, v)
+	}
+
+	switch d.decision {
+	case PolicyDeny:
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	case PolicyChallenge:
+		status := s.ForwardAuthChallengeStatus
+		if status == 0 {
+			status = http.StatusUnauthorized
+		}
+		if status == http.StatusUnauthorized {
+			h.Set("WWW-Authenticate", `Challenge realm="ipquery"`)
+		}
+		http.Error(w, "challenge", status)
 		return
 	}
 
-	if s.RiskChecker != nil {
-		_ = s.RiskChecker.Enrich(ipNet, &res)
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(string(d.decision)))
+}
+
+// forwardAuthDecision looks up and evaluates addr, or returns the cached
+// decision for it.
+func (s *Server) forwardAuthDecision(addr netip.Addr) (forwardAuthDecision, error) {
+	if s.AuthCache != nil {
+		if d, ok := s.AuthCache.cache.Get(addr); ok {
+			return d, nil
+		}
+	}
+
+	res, err := s.Lookup(addr.AsSlice())
+	if err != nil {
+		return forwardAuthDecision{}, err
+	}
+
+	d := forwardAuthDecision{decision: PolicyAllow}
+	if s.AuthPolicy != nil {
+		pr := s.AuthPolicy.Decide(res)
+		d.decision = pr.Decision
+		if pr.Rule != nil {
+			d.rule = pr.Rule.Name
+		}
+	}
+
+	d.headers = map[string]string{
+		"X-Client-IP":       res.IP,
+		"X-Geo-Country":     res.Location.CountryCode,
+		"X-ASN":             res.ISP.ASN,
+		"X-Policy-Decision": string(d.decision),
+	}
+	// Unknown signals are left out rather than reported as 0 or false.
+	if res.Risk.Score != nil {
+		d.headers["X-Risk-Score"] = strconv.Itoa(*res.Risk.Score)
+	}
+	if res.Risk.IsTor != nil {
+		d.headers["X-Is-Tor"] = strconv.FormatBool(*res.Risk.IsTor)
+	}
+	if d.rule != "" {
+		d.headers["X-Policy-Rule"] = d.rule
+	}
+
+	if s.AuthCache != nil {
+		s.AuthCache.cache.Set(addr, d)
+	}
+	return d, nil
+}
+
+func (s *Server) GetSightings(w http.ResponseWriter, r *http.Request) {
+	addr, err := netip.ParseAddr(chi.URLParam(r, "ip"))
+	if err != nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	addr = addr.Unmap()
+
+	sighting, ok := s.Sightings.Get(addr)
+	if !ok {
+		http.Error(w, "ip not seen", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(struct {
+		IP string `json:"ip"`
+		LocalSighting
+	}{IP: addr.String(), LocalSighting: sighting})
+}
+
+func (s *Server) GetBlockRisk(w http.ResponseWriter, r *http.Request) {
+	cidr := chi.URLParam(r, "*")
+	if cidr == "" {
+		http.Error(w, "missing cidr parameter", http.StatusBadRequest)
+		return
+	}
+
+	prefix, err := s.BlockChecker.ParseBlock(cidr)
+	if err != nil {
+		http.Error(w, "invalid cidr: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.BlockChecker.CheckBlock(prefix)
+	switch {
+	case errors.Is(err, ErrAbuseIpDbRateLimited):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		log.Printf("block check %s: %v", prefix, err)
+		http.Error(w, "block check failed", http.StatusBadGateway)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_ = json.NewEncoder(w).Encode(res)
 }
 
+func (s *Server) PostReport(w http.ResponseWriter, r *http.Request) {
+	var rep AbuseReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<10)).Decode(&rep); err != nil {
+		http.Error(w, "invalid report body", http.StatusBadRequest)
+		return
+	}
+
+	if err := rep.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := "queued"
+	code := http.StatusAccepted
+	switch err := s.Reporter.Enqueue(rep); {
+	case errors.Is(err, ErrReportDuplicate):
+		status = "duplicate"
+		code = http.StatusOK
+	case errors.Is(err, ErrReportQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"ip": rep.IP, "status": status})
+}
+
+func (s *Server) GetLists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(s.Lists.Lists())
+}
+
+func (s *Server) PutList(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Kind ManagedListKind `json:"kind"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		http.Error(w, "invalid list body", http.StatusBadRequest)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if err := s.Lists.PutList(name, body.Kind); err != nil {
+		writeListError(w, err, 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]string{"name": name, "kind": string(body.Kind)})
+}
+
+func (s *Server) DeleteList(w http.ResponseWriter, r *http.Request) {
+	writeListError(w, s.Lists.DeleteList(chi.URLParam(r, "name")), http.StatusNoContent)
+}
+
+func (s *Server) GetListEntries(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.Lists.Entries(chi.URLParam(r, "name"))
+	if err != nil {
+		writeListError(w, err, 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func (s *Server) PostListEntry(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ManagedListEntry
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<10)).Decode(&body); err != nil {
+		http.Error(w, "invalid entry body", http.StatusBadRequest)
+		return
+	}
+
+	entry := body.ManagedListEntry
+	if body.TTL != "" {
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		expires := time.Now().UTC().Add(ttl)
+		entry.ExpiresAt = &expires
+	}
+
+	entry, err := s.Lists.PutEntry(chi.URLParam(r, "name"), entry)
+	if err != nil {
+		writeListError(w, err, 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(entry)
+}
+
+func (s *Server) DeleteListEntry(w http.ResponseWriter, r *http.Request) {
+	value := chi.URLParam(r, "*")
+	if value == "" {
+		http.Error(w, "missing ip or cidr", http.StatusBadRequest)
+		return
+	}
+	writeListError(w, s.Lists.DeleteEntry(chi.URLParam(r, "name"), value), http.StatusNoContent)
+}
+
+// writeListError maps managed list errors to status codes, or writes ok if
+// err is nil. Anything but a failed save is the caller's mistake.
+func writeListError(w http.ResponseWriter, err error, ok int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(ok)
+	case errors.Is(err, ErrListNotFound), errors.Is(err, ErrEntryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrListsNotSaved):
+		writeInternalError(w, "managed lists", err)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeInternalError logs err and answers with a generic message, so that
+// details such as file paths or upstream responses don't reach clients.
+func writeInternalError(w http.ResponseWriter, what string, err error) {
+	log.Printf("%s: %v", what, err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
 func (s *Server) Index() http.HandlerFunc {
 	tpl := template.Must(template.New("landing").Parse(landingHTML))
 
@@ -88,7 +496,7 @@ func (s *Server) Index() http.HandlerFunc {
 			return
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_ = tpl.Execute(w, nil)
+		_ = tpl.Execute(w, struct{ OwnV4Url, OwnV6Url string }{s.OwnV4Url, s.OwnV6Url})
 	}
 }
 
@@ -186,6 +594,28 @@ const landingHTML = `<!doctype html>
 
         <p class="mt-3 text-xs text-[hsl(var(--muted-foreground))]">
         </p>
+
+        <!-- dual-stack: your IPv4 and IPv6 addresses -->
+        <div class="mt-2 grid grid-cols-2 gap-2">
+          <button
+            id="ownV4"
+            type="button"
+            class="text-left rounded-[calc(var(--radius)-4px)] border border-[hsl(var(--border))] bg-[hsl(var(--background))] px-3 py-2 hover:bg-[hsl(var(--muted))]"
+          >
+            <div class="text-xs text-[hsl(var(--muted-foreground))]">IPv4 <span id="ownV4Pref" class="hidden font-medium">· preferred</span></div>
+            <div id="ownV4Ip" class="text-sm font-mono font-medium truncate">Checking…</div>
+            <div id="ownV4Info" class="text-xs text-[hsl(var(--muted-foreground))] truncate"></div>
+          </button>
+          <button
+            id="ownV6"
+            type="button"
+            class="text-left rounded-[calc(var(--radius)-4px)] border border-[hsl(var(--border))] bg-[hsl(var(--background))] px-3 py-2 hover:bg-[hsl(var(--muted))]"
+          >
+            <div class="text-xs text-[hsl(var(--muted-foreground))]">IPv6 <span id="ownV6Pref" class="hidden font-medium">· preferred</span></div>
+            <div id="ownV6Ip" class="text-sm font-mono font-medium truncate">Checking…</div>
+            <div id="ownV6Info" class="text-xs text-[hsl(var(--muted-foreground))] truncate"></div>
+          </button>
+        </div>
       </div>
 
       <div class="flex-1 grid grid-cols-2 gap-4 min-h-0">
@@ -288,6 +718,10 @@ const landingHTML = `<!doctype html>
 
   const locationSummary = document.getElementById("locationSummary");
 
+  // family-specific origins of /own/v4 and /own/v6, "" for this origin
+  const ownV4Base = {{.OwnV4Url}};
+  const ownV6Base = {{.OwnV6Url}};
+
   let map = null;
   let marker = null;
 
@@ -570,7 +1004,48 @@ const landingHTML = `<!doctype html>
   }
 
   function loadOwnAll() {
-  	fetchAndRender(apiUrl("/own/all"));
+  	fetchAndRender(apiUrl("/own/all")).then(markPreferredFamily);
+  }
+
+  function familyUrl(base, family) {
+    const path = "/own/" + family + "/all";
+    return base ? base.replace(/\/$/, "") + path : apiUrl(path);
+  }
+
+  // the family of the plain /own/all request is the one the browser prefers
+  function markPreferredFamily() {
+    if (!lastData || !lastData.ip) return;
+    const v6 = lastData.ip.indexOf(":") >= 0;
+    document.getElementById("ownV4Pref").classList.toggle("hidden", v6);
+    document.getElementById("ownV6Pref").classList.toggle("hidden", !v6);
+  }
+
+  async function loadFamily(family, base) {
+    const ipEl = document.getElementById("own" + family.toUpperCase() + "Ip");
+    const infoEl = document.getElementById("own" + family.toUpperCase() + "Info");
+
+    try {
+      const response = await fetch(familyUrl(base, family), { headers: { "Accept": "application/json" } });
+      if (!response.ok) {
+        ipEl.textContent = "Not available";
+        infoEl.textContent = response.status === 404 ? "No " + (family === "v4" ? "IPv4" : "IPv6") + " connectivity" : "HTTP error: " + response.status;
+        return;
+      }
+
+      const data = await response.json();
+      ipEl.textContent = data.ip || "—";
+
+      const loc = data.location || {};
+      const isp = data.isp || {};
+      const parts = [];
+      if (isp.asn) parts.push(isp.asn + (isp.org ? " " + isp.org : ""));
+      if (loc.city || loc.country_code) parts.push([loc.city, loc.country_code].filter(Boolean).join(", "));
+      if (data.risk && typeof data.risk.score === "number") parts.push("risk " + data.risk.score);
+      infoEl.textContent = parts.join(" · ");
+    } catch (err) {
+      ipEl.textContent = "Not available";
+      infoEl.textContent = "No " + (family === "v4" ? "IPv4" : "IPv6") + " connectivity";
+    }
   }
 
   function lookupIP(ip) {
@@ -609,6 +1084,14 @@ const landingHTML = `<!doctype html>
     loadOwnAll();
   });
 
+  document.getElementById("ownV4").addEventListener("click", function () {
+    fetchAndRender(familyUrl(ownV4Base, "v4"));
+  });
+
+  document.getElementById("ownV6").addEventListener("click", function () {
+    fetchAndRender(familyUrl(ownV6Base, "v6"));
+  });
+
   exGoogle.addEventListener("click", function () {
     searchBox.value = "8.8.8.8";
     lookupIP("8.8.8.8");
@@ -634,6 +1117,8 @@ const landingHTML = `<!doctype html>
   // init
   applyViewMode();
   loadOwnAll();
+  loadFamily("v4", ownV4Base);
+  loadFamily("v6", ownV6Base);
 </script>
 </body>
 </html>
@@ -0,0 +1,367 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HostingFormat string
+
+const (
+	HostingFormatAWS          HostingFormat = "aws"
+	HostingFormatGCP          HostingFormat = "gcp"
+	HostingFormatAzure        HostingFormat = "azure"
+	HostingFormatOracle       HostingFormat = "oracle"
+	HostingFormatDigitalOcean HostingFormat = "digitalocean"
+	HostingFormatCloudflare   HostingFormat = "cloudflare"
+	HostingFormatFastly       HostingFormat = "fastly"
+)
+
+type hostingRange struct {
+	prefix netip.Prefix
+	info   HostingInfo
+}
+
+var hostingParsers = map[HostingFormat]func(r io.Reader) ([]hostingRange, error){
+	HostingFormatAWS:          parseAwsRanges,
+	HostingFormatGCP:          parseGcpRanges,
+	HostingFormatAzure:        parseAzureRanges,
+	HostingFormatOracle:       parseOracleRanges,
+	HostingFormatDigitalOcean: parseDigitalOceanRanges,
+	HostingFormatCloudflare:   parseCloudflareRanges,
+	HostingFormatFastly:       parseFastlyRanges,
+}
+
+type HostingSpec struct {
+	Format HostingFormat
+	Source string
+}
+
+// ParseHostingSpec parses "format:source", e.g. "aws:/data/ip-ranges.json".
+func ParseHostingSpec(s string) (HostingSpec, error) {
+	format, source, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || strings.TrimSpace(source) == "" {
+		return HostingSpec{}, fmt.Errorf("bad hosting range %q, expected format:source", s)
+	}
+	if _, known := hostingParsers[HostingFormat(format)]; !known {
+		return HostingSpec{}, fmt.Errorf("bad hosting range %q: unknown format %q", s, format)
+	}
+	return HostingSpec{Format: HostingFormat(format), Source: strings.TrimSpace(source)}, nil
+}
+
+type HostingRanges struct {
+	specs []HostingSpec
+
+	mu     sync.RWMutex
+	ranges map[HostingSpec][]hostingRange
+	trie   *prefixTrie[hostingRange]
+
+	loop refreshLoop
+}
+
+func NewHostingRanges(specs []HostingSpec) *HostingRanges {
+	return &HostingRanges{
+		specs:  specs,
+		ranges: map[HostingSpec][]hostingRange{},
+		trie:   newPrefixTrie[hostingRange](),
+	}
+}
+
+// Start loads all range documents and, if interval is positive, reloads
+// them every interval. A document that fails to load keeps its previous
+// ranges.
+func (h *HostingRanges) Start(interval time.Duration) {
+	h.Refresh()
+	h.loop.start(interval, h.Refresh)
+}
+
+func (h *HostingRanges) Close() error {
+	h.loop.stop()
+	return nil
+}
+
+func (h *HostingRanges) Refresh() {
+	loaded := map[HostingSpec][]hostingRange{}
+	for _, spec := range h.specs {
+		ranges, err := loadHostingRanges(spec)
+		if err != nil {
+			log.Printf("hosting ranges %s (%s): %v", spec.Format, spec.Source, err)
+			continue
+		}
+		loaded[spec] = ranges
+		log.Printf("hosting ranges %s refreshed: %d prefixes", spec.Format, len(ranges))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for spec, ranges := range loaded {
+		h.ranges[spec] = ranges
+	}
+
+	trie := newPrefixTrie[hostingRange]()
+	for _, ranges := range h.ranges {
+		for _, r := range ranges {
+			trie.Insert(r.prefix, r)
+		}
+	}
+	h.trie = trie
+}
+
+func loadHostingRanges(spec HostingSpec) ([]hostingRange, error) {
+	rc, err := openSource(spec.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return hostingParsers[spec.Format](rc)
+}
+
+// Match returns the most specific range containing addr. Between ranges of
+// the same size the one naming a service wins, so that AWS "EC2" is
+// preferred over the catch-all "AMAZON".
+func (h *HostingRanges) Match(addr netip.Addr) (HostingInfo, bool) {
+	h.mu.RLock()
+	matches := h.trie.Lookup(addr)
+	h.mu.RUnlock()
+
+	if len(matches) == 0 {
+		return HostingInfo{}, false
+	}
+
+	best := matches[0]
+	for _, m := range matches[1:] {
+		switch {
+		case m.prefix.Bits() > best.prefix.Bits():
+			best = m
+		case m.prefix.Bits() == best.prefix.Bits() && hostingSpecificity(m.info) > hostingSpecificity(best.info):
+			best = m
+		}
+	}
+	return best.info, true
+}
+
+func hostingSpecificity(info HostingInfo) int {
+	n := 0
+	if info.Region != "" {
+		n++
+	}
+	if info.Service != "" && info.Service != "AMAZON" {
+		n += 2
+	}
+	return n
+}
+
+func (h *HostingRanges) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+
+	if info, ok := h.Match(addr); ok {
+		out.Hosting = &info
+	}
+	return nil
+}
+
+func newHostingRange(cidr string, info HostingInfo) (hostingRange, bool) {
+	p, ok := parsePrefixOrAddr(strings.TrimSpace(cidr))
+	if !ok {
+		return hostingRange{}, false
+	}
+	info.Prefix = p.String()
+	return hostingRange{prefix: p, info: info}, true
+}
+
+// parseAwsRanges reads https://ip-ranges.amazonaws.com/ip-ranges.json
+func parseAwsRanges(r io.Reader) ([]hostingRange, error) {
+	var doc struct {
+		Prefixes []struct {
+			IpPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		Ipv6Prefixes []struct {
+			Ipv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, p := range doc.Prefixes {
+		if hr, ok := newHostingRange(p.IpPrefix, HostingInfo{Provider: "AWS", Region: p.Region, Service: p.Service}); ok {
+			out = append(out, hr)
+		}
+	}
+	for _, p := range doc.Ipv6Prefixes {
+		if hr, ok := newHostingRange(p.Ipv6Prefix, HostingInfo{Provider: "AWS", Region: p.Region, Service: p.Service}); ok {
+			out = append(out, hr)
+		}
+	}
+	return out, nil
+}
+
+// parseGcpRanges reads https://www.gstatic.com/ipranges/cloud.json
+func parseGcpRanges(r io.Reader) ([]hostingRange, error) {
+	var doc struct {
+		Prefixes []struct {
+			Ipv4Prefix string `json:"ipv4Prefix"`
+			Ipv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, p := range doc.Prefixes {
+		info := HostingInfo{Provider: "Google Cloud", Region: p.Scope, Service: p.Service}
+		for _, cidr := range []string{p.Ipv4Prefix, p.Ipv6Prefix} {
+			if cidr == "" {
+				continue
+			}
+			if hr, ok := newHostingRange(cidr, info); ok {
+				out = append(out, hr)
+			}
+		}
+	}
+	return out, nil
+}
+
+// parseAzureRanges reads the weekly Azure Service Tags (ServiceTags_Public_*.json).
+func parseAzureRanges(r io.Reader) ([]hostingRange, error) {
+	var doc struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, v := range doc.Values {
+		service := v.Properties.SystemService
+		if service == "" {
+			service, _, _ = strings.Cut(v.Name, ".")
+		}
+		info := HostingInfo{Provider: "Azure", Region: v.Properties.Region, Service: service}
+		for _, cidr := range v.Properties.AddressPrefixes {
+			if hr, ok := newHostingRange(cidr, info); ok {
+				out = append(out, hr)
+			}
+		}
+	}
+	return out, nil
+}
+
+// parseOracleRanges reads https://docs.oracle.com/iaas/tools/public_ip_ranges.json
+func parseOracleRanges(r io.Reader) ([]hostingRange, error) {
+	var doc struct {
+		Regions []struct {
+			Region string `json:"region"`
+			Cidrs  []struct {
+				Cidr string   `json:"cidr"`
+				Tags []string `json:"tags"`
+			} `json:"cidrs"`
+		} `json:"regions"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, region := range doc.Regions {
+		for _, c := range region.Cidrs {
+			info := HostingInfo{Provider: "Oracle Cloud", Region: region.Region, Service: strings.Join(c.Tags, ",")}
+			if hr, ok := newHostingRange(c.Cidr, info); ok {
+				out = append(out, hr)
+			}
+		}
+	}
+	return out, nil
+}
+
+// parseDigitalOceanRanges reads https://digitalocean.com/geo/google.csv
+// (prefix,country,region,city,postal code).
+func parseDigitalOceanRanges(r io.Reader) ([]hostingRange, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, rec := range records {
+		if len(rec) == 0 {
+			continue
+		}
+		info := HostingInfo{Provider: "DigitalOcean", Service: "Droplets"}
+		switch {
+		case len(rec) > 2 && rec[2] != "":
+			info.Region = rec[2]
+		case len(rec) > 1:
+			info.Region = rec[1]
+		}
+		if hr, ok := newHostingRange(rec[0], info); ok {
+			out = append(out, hr)
+		}
+	}
+	return out, nil
+}
+
+// parseCloudflareRanges reads https://www.cloudflare.com/ips-v4 and ips-v6.
+func parseCloudflareRanges(r io.Reader) ([]hostingRange, error) {
+	prefixes, err := parseFeed(r, parsePlainFeedLine)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]hostingRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, hostingRange{prefix: p, info: HostingInfo{Provider: "Cloudflare", Service: "CDN", Prefix: p.String()}})
+	}
+	return out, nil
+}
+
+// parseFastlyRanges reads https://api.fastly.com/public-ip-list
+func parseFastlyRanges(r io.Reader) ([]hostingRange, error) {
+	var doc struct {
+		Addresses     []string `json:"addresses"`
+		Ipv6Addresses []string `json:"ipv6_addresses"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var out []hostingRange
+	for _, cidr := range append(doc.Addresses, doc.Ipv6Addresses...) {
+		if hr, ok := newHostingRange(cidr, HostingInfo{Provider: "Fastly", Service: "CDN"}); ok {
+			out = append(out, hr)
+		}
+	}
+	return out, nil
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"strings"
@@ -8,41 +11,159 @@ import (
 
 type LookupClient struct {
 	TrustedProxies []*net.IPNet
+	ProxyHeaders   []ProxyHeader
+	ProxyPresets   *ProxyPresets
+	SignedIPs      *SignedClientIP
+	TrustedHops    int
 	AsnReader      *AsnReader
 	CityReader     *CityReader
 	RiskChecker    *AbuseIpDbChecker
+	Enrichers      []Enricher
+	RiskScorer     *RiskScorer
 }
 
+// Lookup runs all configured enrichers for ip. Only the GeoLite2 readers are
+// mandatory; failures of the optional enrichers are logged and leave their
+// fields empty or marked unavailable.
+func (c *LookupClient) Lookup(ip net.IP) (LookupResult, error) {
+	res := LookupResult{IP: ip.String()}
+
+	if err := c.AsnReader.Enrich(ip, &res); err != nil {
+		return res, fmt.Errorf("asn lookup failed: %w", err)
+	}
+
+	if err := c.CityReader.Enrich(ip, &res); err != nil {
+		return res, fmt.Errorf("city lookup failed: %w", err)
+	}
+
+	res.Risk.Status = DataDisabled
+	if c.RiskChecker != nil {
+		if err := c.RiskChecker.Enrich(ip, &res); err != nil {
+			log.Printf("lookup %s: abuseipdb: %v", ip, err)
+			res.Risk.Status = DataUnavailable
+		}
+	}
+
+	for _, e := range c.Enrichers {
+		if err := e.Enrich(ip, &res); err != nil {
+			log.Printf("lookup %s: %v", ip, err)
+		}
+	}
+
+	if c.RiskScorer != nil {
+		c.RiskScorer.Score(&res)
+	}
+
+	return res, nil
+}
+
+// GetClientIP returns the client address of r, as resolved by
+// ClientIPResolver if it ran, or an empty string if there is none.
 func (c *LookupClient) GetClientIP(r *http.Request) string {
-	remoteIP := c.remoteAddrIP(r.RemoteAddr)
-	if remoteIP == nil {
+	info := c.clientIPInfo(r)
+	if !info.Addr.IsValid() {
 		return ""
 	}
+	return info.Addr.String()
+}
 
-	// Only trust forwarded headers if the direct peer is a trusted proxy.
-	if c.isTrustedProxy(remoteIP) {
-		// Cloudflare (optional)
-		if ip := c.parseIP(r.Header.Get("CF-Connecting-IP")); ip != nil {
-			return ip.String()
+// clientIPInfo returns the resolved client address from the request
+// context, resolving it if ClientIPResolver didn't run.
+func (c *LookupClient) clientIPInfo(r *http.Request) ClientIPInfo {
+	if info, ok := ClientIPInfoFromContext(r.Context()); ok {
+		return info
+	}
+	return c.ResolveClientIP(r)
+}
+
+// ResolveClientIP derives the client address of r from its peer and the
+// forwarding headers the peer is trusted for.
+func (c *LookupClient) ResolveClientIP(r *http.Request) ClientIPInfo {
+	remoteIP := c.remoteAddrIP(r.RemoteAddr)
+	peer, ok := netIPToNetipAddr(remoteIP)
+	if !ok {
+		return ClientIPInfo{}
+	}
+	info := ClientIPInfo{Addr: peer, Peer: peer}
+
+	suspicious := func(reason string) {
+		info.Suspicious = append(info.Suspicious, reason)
+	}
+	derived := func(ip net.IP, header string, hop int) bool {
+		addr, ok := netIPToNetipAddr(ip)
+		if !ok {
+			if len(r.Header.Values(header)) > 0 {
+				suspicious(SuspiciousMalformedHeader)
+			}
+			return false
 		}
+		info.Addr, info.Header, info.Hop = addr, header, hop
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+			suspicious(SuspiciousPrivateForwarded)
+		}
+		return true
+	}
+
+	// Headers naming different clients mean one of them was forged or a
+	// proxy is misconfigured, whichever of them is used.
+	if c.hasAddressHeader(r) {
+		if claims, _ := c.headerClaims(r); len(claims) > 1 {
+			suspicious(SuspiciousHeadersDisagree)
+		}
+	}
 
-		// Pangolin/Caddy or other tunnels/reverse proxies gives the real client
-		if ip := c.parseIP(r.Header.Get("X-Real-IP")); ip != nil {
-			return ip.String()
+	// A valid signature vouches for the address whoever the peer is.
+	if c.SignedIPs != nil {
+		addr, err := c.SignedIPs.Verify(r)
+		if err == nil {
+			info.Addr, info.Header = addr, SignedClientIPHeader
+			return info
 		}
+		if !errors.Is(err, ErrSignatureMissing) {
+			suspicious(SuspiciousBadSignature)
+		}
+	}
 
-		// RFC7239 (optional)
-		if ip := c.parseForwardedFor(r.Header.Get("Forwarded")); ip != nil {
-			return ip.String()
+	// A peer with a configured header is trusted for that header only.
+	if p, ok := c.proxyHeaderFor(remoteIP); ok {
+		info.Preset = p.Preset
+		ip, hop := c.clientIPFromHeader(r, p.Header)
+		derived(ip, p.Header, hop)
+		return info
+	}
+
+	// Only trust forwarded headers if the direct peer is a trusted proxy.
+	if !c.isTrustedProxy(remoteIP) {
+		if c.hasAddressHeader(r) {
+			suspicious(SuspiciousUntrustedHeaders)
 		}
+		return info
+	}
 
-		// XFF, if forwarded
-		if ip := c.firstIPFromXFF(r.Header.Get("X-Forwarded-For")); ip != nil {
-			return ip.String()
+	// Cloudflare (optional), then Pangolin/Caddy or other
+	// tunnels/reverse proxies giving the real client, then RFC7239
+	// (optional), then XFF, if forwarded
+	for _, header := range []string{"Cf-Connecting-Ip", "X-Real-Ip", "Forwarded", "X-Forwarded-For"} {
+		if ip, hop := c.clientIPFromHeader(r, header); derived(ip, header, hop) {
+			return info
 		}
 	}
+	return info
+}
 
-	return remoteIP.String()
+// hasAddressHeader reports whether r carries any header naming a client.
+func (c *LookupClient) hasAddressHeader(r *http.Request) bool {
+	for _, h := range addressHeaders {
+		if len(r.Header.Values(h)) > 0 {
+			return true
+		}
+	}
+	for _, p := range c.ProxyHeaders {
+		if len(r.Header.Values(p.Header)) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *LookupClient) isTrustedProxy(remoteIP net.IP) bool {
@@ -51,21 +172,73 @@ func (c *LookupClient) isTrustedProxy(remoteIP net.IP) bool {
 			return true
 		}
 	}
+	for _, p := range c.ProxyHeaders {
+		if p.Network.Contains(remoteIP) {
+			return true
+		}
+	}
+	if c.ProxyPresets != nil {
+		if _, ok := c.ProxyPresets.Match(remoteIP); ok {
+			return true
+		}
+	}
 	return false
 }
 
-func (c *LookupClient) firstIPFromXFF(xff string) net.IP {
-	if xff == "" {
-		return nil
+// clientIPFromXFF walks X-Forwarded-For header lines, which together form
+// one list. Entries may carry a port, as some balancers append one.
+func (c *LookupClient) clientIPFromXFF(values []string) (net.IP, int) {
+	var chain []string
+	for _, v := range strings.Split(strings.Join(values, ","), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		addr, ok := ParseForwardedNode(v)
+		if !ok {
+			// Leave a placeholder that stops the walk.
+			chain = append(chain, "")
+			continue
+		}
+		chain = append(chain, addr.String())
+	}
+	return c.clientFromChain(chain)
+}
+
+// clientFromChain picks the client from forwarded addresses ordered from
+// the client to the nearest proxy. Every proxy appends the address of its
+// own peer, so only the right end of the chain can be trusted: it is walked
+// from the right, skipping trusted proxies, and the first other address is
+// the client. Anything left of it is under the client's control. The hop
+// returned is the client's position counted from the right.
+func (c *LookupClient) clientFromChain(chain []string) (net.IP, int) {
+	if len(chain) == 0 {
+		return nil, 0
 	}
-	parts := strings.Split(xff, ",")
-	for _, p := range parts {
-		ip := c.parseIP(strings.TrimSpace(p))
-		if ip != nil {
-			return ip
+
+	// With a fixed number of proxies in front of ipquery, the client is
+	// the entry that many positions from the right. A shorter chain didn't
+	// pass all of them, so its entries are the client's own and not used.
+	if c.TrustedHops > 0 {
+		if len(chain) < c.TrustedHops {
+			return nil, 0
+		}
+		i := len(chain) - c.TrustedHops
+		return c.parseIP(chain[i]), c.TrustedHops
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := c.parseIP(chain[i])
+		if ip == nil {
+			// A garbled or obfuscated entry; nothing left of it can be
+			// attributed.
+			return nil, 0
+		}
+		if i == 0 || !c.isTrustedProxy(ip) {
+			return ip, len(chain) - i
 		}
 	}
-	return nil
+	return nil, 0
 }
 
 func (c *LookupClient) remoteAddrIP(remoteAddr string) net.IP {
@@ -88,41 +261,3 @@ func (c *LookupClient) parseIP(s string) net.IP {
 	}
 	return ip
 }
-
-func (c *LookupClient) parseForwardedFor(v string) net.IP {
-	// Forwarded: for=203.0.113.60;proto=https;by=...
-	// Can be comma-separated; we take the first entry.
-	if v == "" {
-		return nil
-	}
-	first := strings.Split(v, ",")[0]
-
-	low := strings.ToLower(first)
-	i := strings.Index(low, "for=")
-	if i < 0 {
-		return nil
-	}
-
-	s := first[i+4:]
-	if j := strings.IndexByte(s, ';'); j >= 0 {
-		s = s[:j]
-	}
-	s = strings.TrimSpace(s)
-	s = strings.Trim(s, `"`)
-	s = strings.TrimPrefix(s, "[")
-	s = strings.TrimSuffix(s, "]")
-
-	// Sometimes includes port
-	if host, _, err := net.SplitHostPort(s); err == nil {
-		s = host
-	}
-
-	ip := net.ParseIP(s)
-	if ip == nil {
-		return nil
-	}
-	if v4 := ip.To4(); v4 != nil {
-		return v4
-	}
-	return ip
-}
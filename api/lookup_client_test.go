@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func mustCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var out []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func TestClientFromChain(t *testing.T) {
+	trusted := mustCIDRs(t, "10.0.0.0/8", "2001:db8:ffff::/48")
+
+	tests := []struct {
+		name    string
+		hops    int
+		chain   []string
+		want    string
+		wantHop int
+	}{
+		{name: "empty", chain: nil},
+		{name: "single", chain: []string{"203.0.113.7"}, want: "203.0.113.7", wantHop: 1},
+		{name: "skips trusted", chain: []string{"203.0.113.7", "10.0.0.3", "10.0.0.2"}, want: "203.0.113.7", wantHop: 3},
+		{name: "spoofed left of client", chain: []string{"1.1.1.1", "203.0.113.7", "10.0.0.2"}, want: "203.0.113.7", wantHop: 2},
+		{name: "untrusted hop in the middle", chain: []string{"203.0.113.7", "198.51.100.9", "10.0.0.2"}, want: "198.51.100.9", wantHop: 2},
+		{name: "all trusted", chain: []string{"10.0.0.4", "10.0.0.3"}, want: "10.0.0.4", wantHop: 2},
+		{name: "garbled entry stops the walk", chain: []string{"203.0.113.7", "", "10.0.0.2"}},
+		{name: "ipv6", chain: []string{"2001:db8::1", "2001:db8:ffff::2"}, want: "2001:db8::1", wantHop: 2},
+		{name: "hops", hops: 2, chain: []string{"1.1.1.1", "203.0.113.7", "198.51.100.9"}, want: "203.0.113.7", wantHop: 2},
+		{name: "hops ignore trust", hops: 1, chain: []string{"203.0.113.7", "10.0.0.2"}, want: "10.0.0.2", wantHop: 1},
+		{name: "hops beyond chain", hops: 5, chain: []string{"203.0.113.7", "198.51.100.9"}},
+		{name: "hops exact chain", hops: 2, chain: []string{"203.0.113.7", "198.51.100.9"}, want: "203.0.113.7", wantHop: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &LookupClient{TrustedProxies: trusted, TrustedHops: tt.hops}
+			ip, hop := c.clientFromChain(tt.chain)
+
+			got := ""
+			if ip != nil {
+				got = ip.String()
+			}
+			if got != tt.want || (tt.want != "" && hop != tt.wantHop) {
+				t.Errorf("got %q hop %d, want %q hop %d", got, hop, tt.want, tt.wantHop)
+			}
+		})
+	}
+}
+
+func TestGetClientIPForwardedFor(t *testing.T) {
+	trusted := mustCIDRs(t, "10.0.0.0/8")
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		fwd    []string
+		hops   int
+		want   string
+	}{
+		{name: "untrusted peer", remote: "198.51.100.9:1234", xff: []string{"203.0.113.7"}, want: "198.51.100.9"},
+		{name: "one line", remote: "10.0.0.2:1234", xff: []string{"1.1.1.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{
+			// The client's own line comes first; walking it alone would
+			// return the spoofed address.
+			name:   "multiple lines",
+			remote: "10.0.0.2:1234",
+			xff:    []string{"1.1.1.1", "203.0.113.7, 10.0.0.3"},
+			want:   "203.0.113.7",
+		},
+		{name: "ipv4 with port", remote: "10.0.0.2:1234", xff: []string{"203.0.113.7:5555"}, want: "203.0.113.7"},
+		{name: "ipv6 with port", remote: "10.0.0.2:1234", xff: []string{"1.1.1.1, [2001:db8::1]:5555"}, want: "2001:db8::1"},
+		{name: "ipv6 without brackets", remote: "10.0.0.2:1234", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "forwarded ipv6 with port", remote: "10.0.0.2:1234", fwd: []string{`for=1.1.1.1`, `for="[2001:db8::1]:4711"`}, want: "2001:db8::1"},
+		{name: "garbage falls back to peer", remote: "10.0.0.2:1234", xff: []string{"nonsense"}, want: "10.0.0.2"},
+		{name: "short chain falls back to peer", remote: "10.0.0.2:1234", xff: []string{"1.1.1.1"}, hops: 2, want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			for _, v := range tt.fwd {
+				r.Header.Add("Forwarded", v)
+			}
+
+			c := &LookupClient{TrustedProxies: trusted, TrustedHops: tt.hops}
+			if got := c.GetClientIP(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
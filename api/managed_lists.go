@@ -0,0 +1,359 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ManagedListKind string
+
+const (
+	ManagedListAllow ManagedListKind = "allow"
+	ManagedListDeny  ManagedListKind = "deny"
+)
+
+var (
+	ErrListNotFound  = errors.New("list not found")
+	ErrEntryNotFound = errors.New("entry not found")
+	ErrListsNotSaved = errors.New("managed lists not saved")
+
+	managedListName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+)
+
+type ManagedList struct {
+	Name    string             `json:"name"`
+	Kind    ManagedListKind    `json:"kind"`
+	Entries []ManagedListEntry `json:"entries"`
+}
+
+type ManagedListEntry struct {
+	Value     string     `json:"value"`
+	Comment   string     `json:"comment,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	prefix netip.Prefix
+}
+
+func (e *ManagedListEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+type managedMatch struct {
+	list  string
+	kind  ManagedListKind
+	entry *ManagedListEntry
+}
+
+// ManagedLists are operator-maintained allow- and denylists of IPs and
+// CIDRs. They are kept in memory and written to a JSON file on every change.
+type ManagedLists struct {
+	path string
+
+	mu    sync.RWMutex
+	lists map[string]*ManagedList
+	trie  *prefixTrie[managedMatch]
+}
+
+func NewManagedLists(path string) (*ManagedLists, error) {
+	m := &ManagedLists{path: path, lists: map[string]*ManagedList{}}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var stored []*ManagedList
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+		for _, l := range stored {
+			for i := range l.Entries {
+				p, ok := parsePrefixOrAddr(l.Entries[i].Value)
+				if !ok {
+					return nil, fmt.Errorf("list %s: bad entry %q", l.Name, l.Entries[i].Value)
+				}
+				l.Entries[i].prefix = p
+			}
+			m.lists[l.Name] = l
+		}
+	}
+
+	m.rebuild()
+	log.Printf("managed lists loaded: %d lists", len(m.lists))
+	return m, nil
+}
+
+// rebuild drops expired entries and rebuilds the lookup trie. Callers hold
+// m.mu, or have exclusive access.
+func (m *ManagedLists) rebuild() {
+	now := time.Now().UTC()
+	trie := newPrefixTrie[managedMatch]()
+	for _, l := range m.lists {
+		kept := l.Entries[:0]
+		for _, e := range l.Entries {
+			if !e.expired(now) {
+				kept = append(kept, e)
+			}
+		}
+		l.Entries = kept
+		for i := range l.Entries {
+			trie.Insert(l.Entries[i].prefix, managedMatch{list: l.Name, kind: l.Kind, entry: &l.Entries[i]})
+		}
+	}
+	m.trie = trie
+}
+
+// update applies change and persists the lists. If change fails or the
+// lists can't be saved, the previous lists are restored, so that memory
+// never gets ahead of the file. Callers hold m.mu.
+func (m *ManagedLists) update(change func() error) error {
+	prev := make(map[string]*ManagedList, len(m.lists))
+	for name, l := range m.lists {
+		c := *l
+		c.Entries = append([]ManagedListEntry(nil), l.Entries...)
+		prev[name] = &c
+	}
+
+	err := change()
+	if err == nil {
+		m.rebuild()
+		if serr := m.save(); serr != nil {
+			err = fmt.Errorf("%w: %v", ErrListsNotSaved, serr)
+		}
+	}
+	if err != nil {
+		m.lists = prev
+		m.rebuild()
+	}
+	return err
+}
+
+// save persists all lists. Callers hold m.mu.
+func (m *ManagedLists) save() error {
+	stored := make([]*ManagedList, 0, len(m.lists))
+	for _, l := range m.lists {
+		stored = append(stored, l)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Name < stored[j].Name })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, data)
+}
+
+func ValidManagedListName(name string) bool {
+	return managedListName.MatchString(name)
+}
+
+func (m *ManagedLists) Lists() []ManagedListSummary {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().UTC()
+	out := make([]ManagedListSummary, 0, len(m.lists))
+	for _, l := range m.lists {
+		n := 0
+		for _, e := range l.Entries {
+			if !e.expired(now) {
+				n++
+			}
+		}
+		out = append(out, ManagedListSummary{Name: l.Name, Kind: l.Kind, Entries: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// PutList creates a list, or changes the kind of an existing one.
+func (m *ManagedLists) PutList(name string, kind ManagedListKind) error {
+	if !ValidManagedListName(name) {
+		return fmt.Errorf("invalid list name %q", name)
+	}
+	if kind != ManagedListAllow && kind != ManagedListDeny {
+		return fmt.Errorf("invalid list kind %q", kind)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(func() error {
+		if l, ok := m.lists[name]; ok {
+			l.Kind = kind
+		} else {
+			m.lists[name] = &ManagedList{Name: name, Kind: kind, Entries: []ManagedListEntry{}}
+		}
+		return nil
+	})
+}
+
+func (m *ManagedLists) DeleteList(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(func() error {
+		if _, ok := m.lists[name]; !ok {
+			return ErrListNotFound
+		}
+		delete(m.lists, name)
+		return nil
+	})
+}
+
+func (m *ManagedLists) Entries(name string) ([]ManagedListEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l, ok := m.lists[name]
+	if !ok {
+		return nil, ErrListNotFound
+	}
+
+	now := time.Now().UTC()
+	out := []ManagedListEntry{}
+	for _, e := range l.Entries {
+		if !e.expired(now) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// PutEntry adds an entry to a list, replacing an entry for the same IP or
+// CIDR.
+func (m *ManagedLists) PutEntry(name string, entry ManagedListEntry) (ManagedListEntry, error) {
+	p, ok := parsePrefixOrAddr(entry.Value)
+	if !ok {
+		return ManagedListEntry{}, fmt.Errorf("invalid ip or cidr %q", entry.Value)
+	}
+	entry.prefix = p
+	entry.Value = p.String()
+	if p.IsSingleIP() {
+		entry.Value = p.Addr().String()
+	}
+	entry.CreatedAt = time.Now().UTC()
+	if entry.ExpiresAt != nil && !entry.ExpiresAt.After(entry.CreatedAt) {
+		return ManagedListEntry{}, fmt.Errorf("expires_at is in the past")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.update(func() error {
+		l, ok := m.lists[name]
+		if !ok {
+			return ErrListNotFound
+		}
+
+		for i := range l.Entries {
+			if l.Entries[i].prefix == entry.prefix {
+				l.Entries[i] = entry
+				return nil
+			}
+		}
+		l.Entries = append(l.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return ManagedListEntry{}, err
+	}
+	return entry, nil
+}
+
+func (m *ManagedLists) DeleteEntry(name, value string) error {
+	p, ok := parsePrefixOrAddr(value)
+	if !ok {
+		return fmt.Errorf("invalid ip or cidr %q", value)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(func() error {
+		l, ok := m.lists[name]
+		if !ok {
+			return ErrListNotFound
+		}
+
+		for i := range l.Entries {
+			if l.Entries[i].prefix == p {
+				l.Entries = append(l.Entries[:i], l.Entries[i+1:]...)
+				return nil
+			}
+		}
+		return ErrEntryNotFound
+	})
+}
+
+// Match returns the unexpired entries containing addr in order of
+// precedence: most specific first, denylists before allowlists for the same
+// prefix, and then by list name.
+func (m *ManagedLists) Match(addr netip.Addr) []ManagedListMatch {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().UTC()
+	var matches []managedMatch
+	for _, mm := range m.trie.Lookup(addr) {
+		if !mm.entry.expired(now) {
+			matches = append(matches, mm)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.entry.prefix.Bits() != b.entry.prefix.Bits() {
+			return a.entry.prefix.Bits() > b.entry.prefix.Bits()
+		}
+		if a.kind != b.kind {
+			return a.kind == ManagedListDeny
+		}
+		return a.list < b.list
+	})
+
+	out := []ManagedListMatch{}
+	for _, mm := range matches {
+		out = append(out, ManagedListMatch{
+			List:    mm.list,
+			Kind:    mm.kind,
+			Entry:   mm.entry.Value,
+			Comment: mm.entry.Comment,
+		})
+	}
+	return out
+}
+
+func (m *ManagedLists) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+
+	matches := m.Match(addr)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	out.Risk.ManagedLists = matches
+	for _, mm := range matches {
+		switch mm.Kind {
+		case ManagedListAllow:
+			out.Risk.IsAllowlisted = true
+		case ManagedListDeny:
+			out.Risk.IsDenylisted = true
+		}
+	}
+	return nil
+}
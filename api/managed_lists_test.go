@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManagedListsPrecedence(t *testing.T) {
+	m, err := NewManagedLists(filepath.Join(t.TempDir(), "lists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []struct {
+		name  string
+		kind  ManagedListKind
+		entry string
+	}{
+		{"b-allow", ManagedListAllow, "203.0.113.0/24"},
+		{"a-allow", ManagedListAllow, "203.0.113.0/24"},
+		{"z-deny", ManagedListDeny, "203.0.113.0/24"},
+		{"wide", ManagedListDeny, "203.0.0.0/16"},
+		{"host", ManagedListAllow, "203.0.113.7"},
+	} {
+		if err := m.PutList(l.name, l.kind); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.PutEntry(l.name, ManagedListEntry{Value: l.entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Repeated to catch map iteration order leaking into the result.
+	for range 20 {
+		var got []string
+		for _, mm := range m.Match(netip.MustParseAddr("203.0.113.7")) {
+			got = append(got, mm.List)
+		}
+		want := []string{"host", "z-deny", "a-allow", "b-allow", "wide"}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestManagedListsRollback(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManagedLists(filepath.Join(dir, "lists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutList("deny", ManagedListDeny); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.PutEntry("deny", ManagedListEntry{Value: "198.51.100.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving fails once the directory is gone.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	addr := netip.MustParseAddr("198.51.100.1")
+
+	if _, err := m.PutEntry("deny", ManagedListEntry{Value: "198.51.100.2"}); !errors.Is(err, ErrListsNotSaved) {
+		t.Fatalf("PutEntry: err = %v, want ErrListsNotSaved", err)
+	}
+	if matches := m.Match(netip.MustParseAddr("198.51.100.2")); len(matches) != 0 {
+		t.Errorf("PutEntry: change is live after a failed save: %v", matches)
+	}
+	if err := m.DeleteEntry("deny", "198.51.100.1"); err == nil {
+		t.Fatal("DeleteEntry: expected an error")
+	}
+	if err := m.PutList("deny", ManagedListAllow); err == nil {
+		t.Fatal("PutList: expected an error")
+	}
+	if err := m.DeleteList("deny"); err == nil {
+		t.Fatal("DeleteList: expected an error")
+	}
+	matches := m.Match(addr)
+	if len(matches) != 1 || matches[0].Kind != ManagedListDeny {
+		t.Errorf("matches after failed saves = %v", matches)
+	}
+}
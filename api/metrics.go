@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CounterVec is a counter partitioned by the values of one label.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value]++
+}
+
+func (c *CounterVec) Get(value string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[value]
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", c.name, c.label, v, c.values[v])
+	}
+}
+
+// MetricsHandler serves counters in the Prometheus text format.
+func MetricsHandler(counters ...*CounterVec) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		for _, c := range counters {
+			c.write(&b)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PolicyDecision string
+
+const (
+	PolicyAllow     PolicyDecision = "allow"
+	PolicyChallenge PolicyDecision = "challenge"
+	PolicyDeny      PolicyDecision = "deny"
+)
+
+func (d PolicyDecision) valid() bool {
+	return d == PolicyAllow || d == PolicyChallenge || d == PolicyDeny
+}
+
+// PolicyFacts are the values of a LookupResult that rules can refer to.
+type PolicyFacts map[string]any
+
+// policyFactKind is the type of a fact, used to type-check rules when they
+// are loaded.
+type policyFactKind int
+
+const (
+	policyFactBool policyFactKind = iota
+	policyFactNumber
+	policyFactString
+	policyFactAddr
+	policyFactList
+)
+
+var policyFactNames = map[string]policyFactKind{
+	"ip":               policyFactAddr,
+	"ip_version":       policyFactNumber,
+	"country":          policyFactString,
+	"city":             policyFactString,
+	"asn":              policyFactNumber,
+	"org":              policyFactString,
+	"risk_score":       policyFactNumber,
+	"abuse_score":      policyFactNumber,
+	"is_abusive":       policyFactBool,
+	"is_tor":           policyFactBool,
+	"is_hosting":       policyFactBool,
+	"is_vpn":           policyFactBool,
+	"isp_type":         policyFactString,
+	"hosting_provider": policyFactString,
+	"usage_type":       policyFactString,
+	"total_reports":    policyFactNumber,
+	"lists":            policyFactList,
+	"is_allowlisted":   policyFactBool,
+	"is_denylisted":    policyFactBool,
+	"managed_lists":    policyFactList,
+}
+
+func NewPolicyFacts(res LookupResult) PolicyFacts {
+	addr, _ := netip.ParseAddr(res.IP)
+	addr = addr.Unmap()
+
+	version := 6
+	if addr.Is4() {
+		version = 4
+	}
+
+	asn, _ := strconv.Atoi(strings.TrimPrefix(res.ISP.ASN, "AS"))
+
+	hostingProvider := ""
+	if res.Hosting != nil {
+		hostingProvider = res.Hosting.Provider
+	}
+	_, isHosting := hostingSignal(&res)
+
+	lists := blocklistHits(&res)
+
+	managed := []string{}
+	for _, m := range res.Risk.ManagedLists {
+		managed = append(managed, m.List)
+	}
+
+	return PolicyFacts{
+		"ip":               addr,
+		"ip_version":       version,
+		"country":          res.Location.CountryCode,
+		"city":             res.Location.City,
+		"asn":              asn,
+		"org":              res.ISP.Org,
+		"risk_score":       deref(res.Risk.Score),
+		"abuse_score":      deref(res.Risk.AbuseConfidenceScore),
+		"is_abusive":       deref(res.Risk.IsAbusive),
+		"is_tor":           deref(res.Risk.IsTor),
+		"is_hosting":       isHosting,
+		"is_vpn":           res.ISP.IsVpn,
+		"isp_type":         res.ISP.Type,
+		"hosting_provider": hostingProvider,
+		"usage_type":       deref(res.Risk.UsageType),
+		"total_reports":    deref(res.Risk.TotalReports),
+		"lists":            lists,
+		"is_allowlisted":   res.Risk.IsAllowlisted,
+		"is_denylisted":    res.Risk.IsDenylisted,
+		"managed_lists":    managed,
+	}
+}
+
+// MarshalJSON renders addresses as strings.
+func (f PolicyFacts) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(f))
+	for k, v := range f {
+		if a, ok := v.(netip.Addr); ok {
+			out[k] = a.String()
+			continue
+		}
+		out[k] = v
+	}
+	return json.Marshal(out)
+}
+
+type PolicyRule struct {
+	Name     string         `json:"name"`
+	When     string         `json:"when"`
+	Decision PolicyDecision `json:"decision"`
+
+	expr policyExpr
+}
+
+type PolicySet struct {
+	Default PolicyDecision `json:"default"`
+	Rules   []PolicyRule   `json:"rules"`
+}
+
+// ParsePolicySet parses and validates a JSON rule set.
+func ParsePolicySet(data []byte) (*PolicySet, error) {
+	var ps PolicySet
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, err
+	}
+
+	if ps.Default == "" {
+		ps.Default = PolicyAllow
+	}
+	if !ps.Default.valid() {
+		return nil, fmt.Errorf("invalid default decision %q", ps.Default)
+	}
+
+	for i := range ps.Rules {
+		rule := &ps.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if !rule.Decision.valid() {
+			return nil, fmt.Errorf("rule %s: invalid decision %q", rule.Name, rule.Decision)
+		}
+		expr, err := ParsePolicyExpr(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		rule.expr = expr
+	}
+	return &ps, nil
+}
+
+// Evaluate returns the decision of the first matching rule, or the default
+// decision if none matches. Rules are type-checked when they are parsed, so
+// a rule failing to evaluate means the facts are broken; evaluation stops
+// there and the request is denied rather than let through by a later rule.
+func (ps *PolicySet) Evaluate(facts PolicyFacts) (PolicyDecision, *PolicyRule) {
+	for i := range ps.Rules {
+		rule := &ps.Rules[i]
+		ok, err := rule.expr.eval(facts)
+		if err != nil {
+			log.Printf("policy rule %s: %v, denying", rule.Name, err)
+			return PolicyDeny, rule
+		}
+		if ok {
+			return rule.Decision, rule
+		}
+	}
+	return ps.Default, nil
+}
+
+// PolicyEngine holds the rule set loaded from a file and reloads it when
+// the file changes.
+type PolicyEngine struct {
+	path string
+
+	mu      sync.RWMutex
+	set     *PolicySet
+	modTime time.Time
+
+	loop refreshLoop
+}
+
+func NewPolicyEngine(path string) (*PolicyEngine, error) {
+	e := &PolicyEngine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Start checks the rule file for changes every interval.
+func (e *PolicyEngine) Start(interval time.Duration) {
+	e.loop.start(interval, func() {
+		if err := e.reloadIfChanged(); err != nil {
+			log.Printf("policy %s: %v, keeping previous rules", e.path, err)
+		}
+	})
+}
+
+func (e *PolicyEngine) Close() error {
+	e.loop.stop()
+	return nil
+}
+
+func (e *PolicyEngine) reloadIfChanged() error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+
+	e.mu.RLock()
+	changed := !fi.ModTime().Equal(e.modTime)
+	e.mu.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	if err := e.Reload(); err != nil {
+		// Remember the broken version so it is reported only once.
+		e.mu.Lock()
+		e.modTime = fi.ModTime()
+		e.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (e *PolicyEngine) Reload() error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	set, err := ParsePolicySet(data)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.set = set
+	e.modTime = fi.ModTime()
+	e.mu.Unlock()
+
+	log.Printf("policy %s loaded: %d rules, default %s", e.path, len(set.Rules), set.Default)
+	return nil
+}
+
+func (e *PolicyEngine) Decide(res LookupResult) PolicyResult {
+	e.mu.RLock()
+	set := e.set
+	e.mu.RUnlock()
+
+	facts := NewPolicyFacts(res)
+	decision, rule := set.Evaluate(facts)
+
+	out := PolicyResult{IP: res.IP, Decision: decision, Facts: facts}
+	if rule != nil {
+		out.Rule = &PolicyMatchedRule{Name: rule.Name, When: rule.When}
+	}
+	return out
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policy conditions are small boolean expressions over the facts of a
+// lookup, e.g.
+//
+//	country in [KP, IR] or (risk_score >= 80 and not is_hosting)
+//	ip in [10.0.0.0/8, 192.168.1.10]
+//	asn in [AS13335, 15169]
+//
+// A bare fact name tests a boolean fact. For list facts such as "lists",
+// "in" is true if any element is in the given list.
+
+type policyExpr interface {
+	eval(facts PolicyFacts) (bool, error)
+}
+
+type policyAnd struct{ left, right policyExpr }
+type policyOr struct{ left, right policyExpr }
+type policyNot struct{ inner policyExpr }
+
+type policyCond struct {
+	fact   string
+	op     string
+	values []string
+}
+
+func (e policyAnd) eval(f PolicyFacts) (bool, error) {
+	l, err := e.left.eval(f)
+	if err != nil || !l {
+		return false, err
+	}
+	return e.right.eval(f)
+}
+
+func (e policyOr) eval(f PolicyFacts) (bool, error) {
+	l, err := e.left.eval(f)
+	if err != nil || l {
+		return l, err
+	}
+	return e.right.eval(f)
+}
+
+func (e policyNot) eval(f PolicyFacts) (bool, error) {
+	v, err := e.inner.eval(f)
+	return !v, err
+}
+
+func (c policyCond) eval(f PolicyFacts) (bool, error) {
+	v, ok := f[c.fact]
+	if !ok {
+		return false, fmt.Errorf("unknown fact %q", c.fact)
+	}
+
+	switch c.op {
+	case "":
+		b, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("fact %q is not a boolean", c.fact)
+		}
+		return b, nil
+	case "in", "not in":
+		in := c.matchAny(v)
+		if c.op == "not in" {
+			return !in, nil
+		}
+		return in, nil
+	case "==", "!=":
+		eq := c.matchAny(v)
+		if c.op == "!=" {
+			return !eq, nil
+		}
+		return eq, nil
+	case ">", ">=", "<", "<=":
+		n, ok := toFloat(v)
+		if !ok {
+			return false, fmt.Errorf("fact %q is not a number", c.fact)
+		}
+		want, err := strconv.ParseFloat(c.values[0], 64)
+		if err != nil {
+			return false, fmt.Errorf("%q is not a number", c.values[0])
+		}
+		switch c.op {
+		case ">":
+			return n > want, nil
+		case ">=":
+			return n >= want, nil
+		case "<":
+			return n < want, nil
+		default:
+			return n <= want, nil
+		}
+	}
+	return false, fmt.Errorf("unknown operator %q", c.op)
+}
+
+func (c policyCond) matchAny(v any) bool {
+	switch fv := v.(type) {
+	case []string:
+		for _, item := range fv {
+			if c.matchValue(item) {
+				return true
+			}
+		}
+		return false
+	default:
+		return c.matchValue(fv)
+	}
+}
+
+func (c policyCond) matchValue(v any) bool {
+	for _, want := range c.values {
+		switch fv := v.(type) {
+		case netip.Addr:
+			if p, ok := parsePrefixOrAddr(want); ok && p.Contains(fv.Unmap()) {
+				return true
+			}
+		case bool:
+			if b, err := strconv.ParseBool(want); err == nil && b == fv {
+				return true
+			}
+		case int, float64:
+			n, _ := toFloat(fv)
+			if w, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToUpper(want), "AS"), 64); err == nil && w == n {
+				return true
+			}
+		case string:
+			if strings.EqualFold(fv, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// ParsePolicyExpr parses a rule condition.
+func ParsePolicyExpr(s string) (policyExpr, error) {
+	toks, err := tokenizePolicy(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return e, nil
+}
+
+func tokenizePolicy(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		ch := rune(s[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case strings.ContainsRune("()[],", ch):
+			toks = append(toks, string(ch))
+			i++
+		case strings.ContainsRune("<>=!", ch):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case ch == '"' || ch == '\'':
+			j := strings.IndexByte(s[i+1:], s[i])
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, s[i:i+j+2])
+			i += j + 2
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()[],<>=!\"'", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type policyParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *policyParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *policyParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = policyOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = policyAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseUnary() (policyExpr, error) {
+	switch t := p.peek(); {
+	case strings.EqualFold(t, "not"):
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return policyNot{inner}, nil
+	case t == "(":
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	}
+	return p.parseCond()
+}
+
+func (p *policyParser) parseCond() (policyExpr, error) {
+	fact := strings.ToLower(p.next())
+	if fact == "" || !isPolicyIdent(fact) {
+		return nil, fmt.Errorf("expected fact name, got %q", fact)
+	}
+	kind, ok := policyFactNames[fact]
+	if !ok {
+		return nil, fmt.Errorf("unknown fact %q", fact)
+	}
+
+	cond := policyCond{fact: fact}
+	switch op := strings.ToLower(p.peek()); op {
+	case "in":
+		p.next()
+		cond.op = "in"
+	case "not":
+		if p.pos+1 < len(p.toks) && strings.EqualFold(p.toks[p.pos+1], "in") {
+			p.pos += 2
+			cond.op = "not in"
+		} else {
+			return cond, cond.check(kind)
+		}
+	case "==", "!=", ">", ">=", "<", "<=":
+		p.next()
+		cond.op = op
+	default:
+		return cond, cond.check(kind)
+	}
+
+	if cond.op == "in" || cond.op == "not in" {
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		cond.values = values
+		return cond, cond.check(kind)
+	}
+
+	v := p.next()
+	if v == "" || strings.ContainsAny(v, "()[],") {
+		return nil, fmt.Errorf("expected value after %s", cond.op)
+	}
+	cond.values = []string{unquotePolicy(v)}
+	return cond, cond.check(kind)
+}
+
+// check rejects conditions that can never evaluate against a fact of kind,
+// so that a broken rule fails when it is loaded rather than on every request.
+func (c policyCond) check(kind policyFactKind) error {
+	switch c.op {
+	case "":
+		if kind != policyFactBool {
+			return fmt.Errorf("fact %q is not a boolean, compare it with an operator", c.fact)
+		}
+		return nil
+	case ">", ">=", "<", "<=":
+		if kind != policyFactNumber {
+			return fmt.Errorf("fact %q is not a number, %s needs a number", c.fact, c.op)
+		}
+	}
+
+	for _, v := range c.values {
+		var ok bool
+		switch kind {
+		case policyFactBool:
+			_, err := strconv.ParseBool(v)
+			ok = err == nil
+		case policyFactNumber:
+			if c.op == "in" || c.op == "not in" || c.op == "==" || c.op == "!=" {
+				v = strings.TrimPrefix(strings.ToUpper(v), "AS")
+			}
+			_, err := strconv.ParseFloat(v, 64)
+			ok = err == nil
+		case policyFactAddr:
+			_, ok = parsePrefixOrAddr(v)
+		default:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("fact %q: invalid value %q", c.fact, v)
+		}
+	}
+	return nil
+}
+
+func (p *policyParser) parseList() ([]string, error) {
+	if p.next() != "[" {
+		return nil, fmt.Errorf("expected [")
+	}
+	var out []string
+	for {
+		t := p.next()
+		switch t {
+		case "":
+			return nil, fmt.Errorf("missing ]")
+		case "]":
+			return out, nil
+		case ",":
+			continue
+		default:
+			out = append(out, unquotePolicy(t))
+		}
+	}
+}
+
+func isPolicyIdent(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func unquotePolicy(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
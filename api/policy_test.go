@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicyExpr(t *testing.T) {
+	tests := []struct {
+		when    string
+		wantErr string
+	}{
+		{when: "is_tor"},
+		{when: "not is_hosting and (risk_score >= 80 or country in [KP, IR])"},
+		{when: "ip in [10.0.0.0/8, 192.168.1.10]"},
+		{when: "asn in [AS13335, 15169]"},
+		{when: "asn == AS13335"},
+		{when: "is_tor == true"},
+		{when: "lists not in [spamhaus-drop]"},
+		{when: "country", wantErr: "not a boolean"},
+		{when: "not managed_lists", wantErr: "not a boolean"},
+		{when: "country > 3", wantErr: "not a number"},
+		{when: "lists >= 1", wantErr: "not a number"},
+		{when: "risk_score > high", wantErr: "invalid value"},
+		{when: "ip in [10.0.0.0/33]", wantErr: "invalid value"},
+		{when: "asn in [cloudflare]", wantErr: "invalid value"},
+		{when: "is_tor == yes", wantErr: "invalid value"},
+		{when: "is_evil", wantErr: "unknown fact"},
+		{when: "(is_tor", wantErr: "missing )"},
+		{when: "country in [DE", wantErr: "missing ]"},
+		{when: "risk_score >", wantErr: "expected value"},
+		{when: "is_tor is_vpn", wantErr: "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			_, err := ParsePolicyExpr(tt.when)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePolicySet(t *testing.T) {
+	if _, err := ParsePolicySet([]byte(`{"rules": [{"when": "country", "decision": "deny"}]}`)); err == nil || !strings.Contains(err.Error(), "rule-1") {
+		t.Errorf("bare string fact: err = %v", err)
+	}
+	if _, err := ParsePolicySet([]byte(`{"rules": [{"when": "is_tor", "decision": "block"}]}`)); err == nil {
+		t.Error("invalid decision: expected an error")
+	}
+	if _, err := ParsePolicySet([]byte(`{"default": "maybe"}`)); err == nil {
+		t.Error("invalid default: expected an error")
+	}
+
+	ps, err := ParsePolicySet([]byte(`{"rules": [{"when": "is_tor", "decision": "deny"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Default != PolicyAllow || ps.Rules[0].Name != "rule-1" {
+		t.Errorf("defaults not applied: %+v", ps)
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	ps, err := ParsePolicySet([]byte(`{
+		"default": "allow",
+		"rules": [
+			{"name": "office", "when": "ip in [198.51.100.0/24]", "decision": "allow"},
+			{"name": "tor", "when": "is_tor", "decision": "deny"},
+			{"name": "risky", "when": "risk_score >= 80 and not is_allowlisted", "decision": "challenge"},
+			{"name": "hosting", "when": "asn in [AS16509] or lists in [firehol]", "decision": "challenge"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facts := func(ip string, overrides PolicyFacts) PolicyFacts {
+		f := NewPolicyFacts(LookupResult{IP: ip})
+		for k, v := range overrides {
+			f[k] = v
+		}
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		facts    PolicyFacts
+		decision PolicyDecision
+		rule     string
+	}{
+		{name: "default", facts: facts("203.0.113.7", nil), decision: PolicyAllow},
+		{name: "first match wins", facts: facts("198.51.100.9", PolicyFacts{"is_tor": true}), decision: PolicyAllow, rule: "office"},
+		{name: "bool fact", facts: facts("203.0.113.7", PolicyFacts{"is_tor": true}), decision: PolicyDeny, rule: "tor"},
+		{name: "number", facts: facts("203.0.113.7", PolicyFacts{"risk_score": 85}), decision: PolicyChallenge, rule: "risky"},
+		{name: "not", facts: facts("203.0.113.7", PolicyFacts{"risk_score": 85, "is_allowlisted": true}), decision: PolicyAllow},
+		{name: "list fact", facts: facts("203.0.113.7", PolicyFacts{"lists": []string{"spamhaus", "firehol"}}), decision: PolicyChallenge, rule: "hosting"},
+		{name: "asn prefix", facts: facts("203.0.113.7", PolicyFacts{"asn": 16509}), decision: PolicyChallenge, rule: "hosting"},
+		// A broken fact fails closed instead of skipping to later rules.
+		{name: "runtime error", facts: facts("203.0.113.7", PolicyFacts{"is_tor": "yes", "risk_score": 85}), decision: PolicyDeny, rule: "tor"},
+		{name: "missing fact", facts: func() PolicyFacts { f := facts("203.0.113.7", nil); delete(f, "is_tor"); return f }(), decision: PolicyDeny, rule: "tor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, rule := ps.Evaluate(tt.facts)
+			name := ""
+			if rule != nil {
+				name = rule.Name
+			}
+			if decision != tt.decision || name != tt.rule {
+				t.Errorf("got %s by %q, want %s by %q", decision, name, tt.decision, tt.rule)
+			}
+		})
+	}
+
+	if addr := facts("::ffff:198.51.100.9", nil)["ip"].(netip.Addr); !addr.Is4() {
+		t.Errorf("mapped address not unmapped: %v", addr)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import "net/netip"
+
+// prefixTrie is a binary trie over address bits that maps prefixes to
+// values. IPv4 and IPv6 are kept in separate roots so IPv4 prefixes never
+// match IPv6 addresses.
+type prefixTrie[V any] struct {
+	v4, v6 *trieNode[V]
+	size   int
+}
+
+type trieNode[V any] struct {
+	children [2]*trieNode[V]
+	values   []V
+}
+
+func newPrefixTrie[V any]() *prefixTrie[V] {
+	return &prefixTrie[V]{v4: &trieNode[V]{}, v6: &trieNode[V]{}}
+}
+
+func (t *prefixTrie[V]) root(addr netip.Addr) *trieNode[V] {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func (t *prefixTrie[V]) Insert(prefix netip.Prefix, value V) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	b := addr.AsSlice()
+
+	n := t.root(addr)
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := (b[i/8] >> (7 - uint(i%8))) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &trieNode[V]{}
+		}
+		n = n.children[bit]
+	}
+	n.values = append(n.values, value)
+	t.size++
+}
+
+// Lookup returns the values of every prefix containing addr, shortest
+// prefix first.
+func (t *prefixTrie[V]) Lookup(addr netip.Addr) []V {
+	addr = addr.Unmap()
+	b := addr.AsSlice()
+
+	var out []V
+	n := t.root(addr)
+	for i := 0; n != nil; i++ {
+		out = append(out, n.values...)
+		if i == addr.BitLen() {
+			break
+		}
+		bit := (b[i/8] >> (7 - uint(i%8))) & 1
+		n = n.children[bit]
+	}
+	return out
+}
+
+func (t *prefixTrie[V]) Len() int { return t.size }
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyHeader names the one forwarding header that is authoritative for
+// requests from a trusted network, e.g. CF-Connecting-IP for Cloudflare's
+// ranges. All other forwarding headers from that network are ignored.
+type ProxyHeader struct {
+	Network *net.IPNet
+	Header  string
+	// Preset names the CDN preset the network belongs to, if any.
+	Preset string
+}
+
+// ParseProxyHeader parses "<cidr>=<header>".
+func ParseProxyHeader(s string) (ProxyHeader, error) {
+	cidr, header, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return ProxyHeader{}, fmt.Errorf("%q: expected <cidr>=<header>", s)
+	}
+
+	_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return ProxyHeader{}, fmt.Errorf("%q: bad cidr: %w", s, err)
+	}
+
+	header = strings.TrimSpace(header)
+	if header == "" || strings.ContainsFunc(header, func(r rune) bool {
+		return !(r == '-' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+	}) {
+		return ProxyHeader{}, fmt.Errorf("%q: bad header name %q", s, header)
+	}
+
+	return ProxyHeader{Network: n, Header: http.CanonicalHeaderKey(header)}, nil
+}
+
+func (p ProxyHeader) String() string {
+	return p.Network.String() + "=" + p.Header
+}
+
+// proxyHeaderFor returns the authoritative header for a peer, picking the
+// most specific network if several match. Configured networks win over
+// presets of the same size.
+func (c *LookupClient) proxyHeaderFor(remoteIP net.IP) (ProxyHeader, bool) {
+	var best ProxyHeader
+	bestBits := -1
+	for _, p := range c.ProxyHeaders {
+		if !p.Network.Contains(remoteIP) {
+			continue
+		}
+		if bits, _ := p.Network.Mask.Size(); bits > bestBits {
+			best, bestBits = p, bits
+		}
+	}
+	if c.ProxyPresets != nil {
+		if p, ok := c.ProxyPresets.Match(remoteIP); ok {
+			if bits, _ := p.Network.Mask.Size(); bits > bestBits {
+				best, bestBits = p, bits
+			}
+		}
+	}
+	return best, bestBits >= 0
+}
+
+// clientIPFromHeader reads the client address from one forwarding header,
+// along with its hop if the header lists a chain.
+func (c *LookupClient) clientIPFromHeader(r *http.Request, header string) (net.IP, int) {
+	switch header {
+	case "Forwarded":
+		return c.clientIPFromForwarded(r.Header.Values("Forwarded"))
+	case "X-Forwarded-For":
+		return c.clientIPFromXFF(r.Header.Values("X-Forwarded-For"))
+	default:
+		return c.parseIP(r.Header.Get(header)), 0
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// proxyPresetHeaders are the CDNs ipquery knows the edge ranges of, and the
+// header each of them sets to the client address.
+var proxyPresetHeaders = map[string]string{
+	"cloudflare": "Cf-Connecting-Ip",
+	"fastly":     "Fastly-Client-Ip",
+	// CloudFront appends the viewer to X-Forwarded-For; its own
+	// CloudFront-Viewer-Address must be enabled per distribution.
+	"cloudfront": "X-Forwarded-For",
+}
+
+func ProxyPresetNames() []string {
+	names := make([]string, 0, len(proxyPresetHeaders))
+	for name := range proxyPresetHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type presetRange struct {
+	prefix netip.Prefix
+	preset string
+}
+
+// ProxyPresets trusts the edge ranges of well-known CDNs, each for the one
+// header that CDN sets. The ranges are read from bundled lists, or from
+// files or URLs in the providers' own formats, and can be refreshed.
+type ProxyPresets struct {
+	sources map[string]string
+
+	mu     sync.RWMutex
+	ranges map[string][]netip.Prefix
+	trie   *prefixTrie[presetRange]
+
+	loop refreshLoop
+}
+
+// NewProxyPresets prepares the named presets. Their ranges come from
+// sources[name] if set, and from "<dir>/<name>.txt" otherwise.
+func NewProxyPresets(names []string, dir string, sources map[string]string) (*ProxyPresets, error) {
+	p := &ProxyPresets{
+		sources: map[string]string{},
+		ranges:  map[string][]netip.Prefix{},
+		trie:    newPrefixTrie[presetRange](),
+	}
+
+	for _, name := range names {
+		if _, ok := proxyPresetHeaders[name]; !ok {
+			return nil, fmt.Errorf("unknown preset %q, expected one of %v", name, ProxyPresetNames())
+		}
+		p.sources[name] = filepath.Join(dir, name+".txt")
+	}
+	for name, src := range sources {
+		if _, ok := p.sources[name]; !ok {
+			return nil, fmt.Errorf("source for preset %q which is not enabled", name)
+		}
+		p.sources[name] = src
+	}
+	return p, nil
+}
+
+// Start loads the ranges and, if interval is positive, reloads them every
+// interval. A preset that fails to load keeps its previous ranges.
+func (p *ProxyPresets) Start(interval time.Duration) {
+	p.Refresh()
+	p.loop.start(interval, p.Refresh)
+}
+
+func (p *ProxyPresets) Close() error {
+	p.loop.stop()
+	return nil
+}
+
+func (p *ProxyPresets) Refresh() {
+	loaded := map[string][]netip.Prefix{}
+	for name, src := range p.sources {
+		prefixes, err := loadPresetRanges(src)
+		if err == nil && len(prefixes) == 0 {
+			err = fmt.Errorf("no ranges found")
+		}
+		if err != nil {
+			log.Printf("trusted proxy preset %s (%s): %v", name, src, err)
+			continue
+		}
+		loaded[name] = prefixes
+		log.Printf("trusted proxy preset %s loaded: %d prefixes, header %s", name, len(prefixes), proxyPresetHeaders[name])
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, prefixes := range loaded {
+		p.ranges[name] = prefixes
+	}
+
+	trie := newPrefixTrie[presetRange]()
+	for name, prefixes := range p.ranges {
+		for _, prefix := range prefixes {
+			trie.Insert(prefix, presetRange{prefix: prefix, preset: name})
+		}
+	}
+	p.trie = trie
+}
+
+func loadPresetRanges(src string) ([]netip.Prefix, error) {
+	rc, err := openSource(src)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return parsePresetRanges(rc)
+}
+
+// parsePresetRanges reads a plain list of CIDRs, or one of the JSON
+// documents the CDNs publish their ranges in.
+func parsePresetRanges(r io.Reader) ([]netip.Prefix, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return parseFeed(bytes.NewReader(data), parsePlainFeedLine)
+	}
+
+	var doc struct {
+		// https://api.cloudflare.com/client/v4/ips
+		Result struct {
+			Ipv4Cidrs []string `json:"ipv4_cidrs"`
+			Ipv6Cidrs []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+		// https://api.fastly.com/public-ip-list
+		Addresses     []string `json:"addresses"`
+		Ipv6Addresses []string `json:"ipv6_addresses"`
+		// https://d7uri8nf7uskq.cloudfront.net/tools/list-cloudfront-ips
+		CloudFrontGlobal   []string `json:"CLOUDFRONT_GLOBAL_IP_LIST"`
+		CloudFrontRegional []string `json:"CLOUDFRONT_REGIONAL_EDGE_IP_LIST"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var out []netip.Prefix
+	for _, list := range [][]string{
+		doc.Result.Ipv4Cidrs, doc.Result.Ipv6Cidrs,
+		doc.Addresses, doc.Ipv6Addresses,
+		doc.CloudFrontGlobal, doc.CloudFrontRegional,
+	} {
+		for _, cidr := range list {
+			if prefix, ok := parsePrefixOrAddr(cidr); ok {
+				out = append(out, prefix)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Match returns the header the CDN owning remoteIP sets, with the most
+// specific matching range as its network.
+func (p *ProxyPresets) Match(remoteIP net.IP) (ProxyHeader, bool) {
+	addr, ok := netIPToNetipAddr(remoteIP)
+	if !ok {
+		return ProxyHeader{}, false
+	}
+
+	p.mu.RLock()
+	matches := p.trie.Lookup(addr.Unmap())
+	p.mu.RUnlock()
+
+	if len(matches) == 0 {
+		return ProxyHeader{}, false
+	}
+
+	// Lookup returns the shortest prefix first.
+	m := matches[len(matches)-1]
+	return ProxyHeader{
+		Network: &net.IPNet{IP: m.prefix.Addr().AsSlice(), Mask: net.CIDRMask(m.prefix.Bits(), m.prefix.Addr().BitLen())},
+		Header:  proxyPresetHeaders[m.preset],
+		Preset:  m.preset,
+	}, true
+}
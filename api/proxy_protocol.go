@@ -0,0 +1,420 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyProtocolDefaultTimeout = 5 * time.Second
+	proxyProtocolV1MaxLen       = 107
+)
+
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrProxyProtocolMissing is returned for connections from trusted peers
+// that don't start with a PROXY protocol header, if one is required.
+var ErrProxyProtocolMissing = errors.New("proxy protocol: header missing")
+
+// PROXY protocol v2 TLV types, see
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	pp2TypeAlpn      = 0x01
+	pp2TypeAuthority = 0x02
+	pp2TypeUniqueId  = 0x05
+	pp2TypeSsl       = 0x20
+	pp2SubTypeSslVer = 0x21
+	pp2SubTypeSslCn  = 0x22
+	pp2SubTypeCipher = 0x23
+	pp2SubTypeSigAlg = 0x24
+	pp2SubTypeKeyAlg = 0x25
+	pp2TypeNetns     = 0x30
+	pp2TypeAws       = 0xea
+	pp2SubTypeAwsVpc = 0x01
+
+	pp2ClientSsl      = 0x01
+	pp2ClientCertConn = 0x02
+	pp2ClientCertSess = 0x04
+)
+
+// ProxyProtocolInfo is what a load balancer told about a connection in its
+// PROXY protocol header.
+type ProxyProtocolInfo struct {
+	Version     int                   `json:"version"`
+	Source      string                `json:"source,omitempty"`
+	Destination string                `json:"destination,omitempty"`
+	Alpn        string                `json:"alpn,omitempty"`
+	Authority   string                `json:"authority,omitempty"`
+	UniqueId    string                `json:"unique_id,omitempty"`
+	Netns       string                `json:"netns,omitempty"`
+	AwsVpceId   string                `json:"aws_vpce_id,omitempty"`
+	SSL         *ProxyProtocolSSLInfo `json:"ssl,omitempty"`
+}
+
+type ProxyProtocolSSLInfo struct {
+	ClientSSL      bool   `json:"client_ssl"`
+	ClientCertConn bool   `json:"client_cert_conn"`
+	ClientCertSess bool   `json:"client_cert_sess"`
+	Verified       bool   `json:"verified"`
+	Version        string `json:"version,omitempty"`
+	CommonName     string `json:"cn,omitempty"`
+	Cipher         string `json:"cipher,omitempty"`
+	SigAlg         string `json:"sig_alg,omitempty"`
+	KeyAlg         string `json:"key_alg,omitempty"`
+}
+
+// ProxyProtocolListener accepts connections that start with a PROXY protocol
+// v1 or v2 header and reports the source address from the header as their
+// remote address. Headers are only read from peers in Trusted; connections
+// from anywhere else are passed through unchanged.
+type ProxyProtocolListener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	// Timeout bounds reading the header.
+	Timeout time.Duration
+	// Optional lets trusted peers connect without a header, keeping their
+	// own address. Otherwise such connections are closed.
+	Optional bool
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.trusted(tcpAddr.IP) {
+		return conn, nil
+	}
+
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = ProxyProtocolDefaultTimeout
+	}
+	// The header is read lazily by the connection's own goroutine, so that
+	// a slow peer doesn't hold up Accept.
+	return &proxyProtocolConn{Conn: conn, r: bufio.NewReader(conn), timeout: timeout, required: !l.Optional}, nil
+}
+
+func (l *ProxyProtocolListener) trusted(ip net.IP) bool {
+	for _, n := range l.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	r        *bufio.Reader
+	timeout  time.Duration
+	required bool
+
+	once   sync.Once
+	err    error
+	source net.Addr
+	info   *ProxyProtocolInfo
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.source, c.info, c.err = readProxyProtocolHeader(c.r, c.required)
+		if c.err != nil {
+			// Don't answer a peer that failed to identify the client.
+			_ = c.Conn.Close()
+			return
+		}
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtocolHeader consumes a PROXY protocol header if r starts with
+// one. It returns the source address, or nil if the header carries none.
+func readProxyProtocolHeader(r *bufio.Reader, required bool) (net.Addr, *ProxyProtocolInfo, error) {
+	sig, err := r.Peek(len(proxyProtocolV2Sig))
+	if err == nil && bytes.Equal(sig, proxyProtocolV2Sig) {
+		return readProxyProtocolV2(r)
+	}
+	if len(sig) >= 6 && string(sig[:6]) == "PROXY " {
+		return readProxyProtocolV1(r)
+	}
+	if len(sig) == 0 || (err != nil && bytes.HasPrefix(proxyProtocolV2Sig, sig)) {
+		// Nothing, or a truncated v2 signature, arrived in time.
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	if required {
+		return nil, nil, ErrProxyProtocolMissing
+	}
+	return nil, nil, nil
+}
+
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, *ProxyProtocolInfo, error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("proxy protocol: v1 header too long or not terminated")
+	}
+
+	fields := strings.Split(text, " ")
+	info := &ProxyProtocolInfo{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, info, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxy protocol: bad v1 header %q", text)
+	}
+
+	src, err := parseProxyProtocolV1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Source = src.String()
+	info.Destination = dst.String()
+	return src, info, nil
+}
+
+func parseProxyProtocolV1Addr(host, port string, v6 bool) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil || addr.Is6() != v6 {
+		return nil, fmt.Errorf("proxy protocol: bad v1 address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: bad v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, *ProxyProtocolInfo, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported version %d", hdr[12]>>4)
+	}
+	cmd := hdr[12] & 0x0f
+	family := hdr[13] >> 4
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	info := &ProxyProtocolInfo{Version: 2}
+
+	var src, dst *net.TCPAddr
+	var tlvs []byte
+	switch family {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, nil, errors.New("proxy protocol: short v2 ipv4 addresses")
+		}
+		src = v2Addr(body[0:4], body[8:10])
+		dst = v2Addr(body[4:8], body[10:12])
+		tlvs = body[12:]
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, nil, errors.New("proxy protocol: short v2 ipv6 addresses")
+		}
+		src = v2Addr(body[0:16], body[32:34])
+		dst = v2Addr(body[16:32], body[34:36])
+		tlvs = body[36:]
+	case 0x3: // AF_UNIX carries no usable address
+		if len(body) >= 216 {
+			tlvs = body[216:]
+		}
+	default:
+		tlvs = body
+	}
+
+	if err := parseProxyProtocolTLVs(tlvs, info); err != nil {
+		return nil, nil, err
+	}
+
+	switch cmd {
+	case 0x0:
+		// LOCAL connections are the balancer's own, e.g. health checks.
+		return nil, info, nil
+	case 0x1:
+		if src == nil {
+			return nil, info, nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported command %d", cmd)
+	}
+	info.Source = src.String()
+	info.Destination = dst.String()
+	return src, info, nil
+}
+
+func v2Addr(ip, port []byte) *net.TCPAddr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), binary.BigEndian.Uint16(port)))
+}
+
+func parseProxyProtocolTLVs(b []byte, info *ProxyProtocolInfo) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return errors.New("proxy protocol: truncated tlv")
+		}
+		typ := b[0]
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return errors.New("proxy protocol: truncated tlv")
+		}
+		value := b[3 : 3+n]
+		b = b[3+n:]
+
+		switch typ {
+		case pp2TypeAlpn:
+			info.Alpn = string(value)
+		case pp2TypeAuthority:
+			info.Authority = string(value)
+		case pp2TypeUniqueId:
+			info.UniqueId = hex.EncodeToString(value)
+		case pp2TypeNetns:
+			info.Netns = string(value)
+		case pp2TypeAws:
+			if len(value) > 1 && value[0] == pp2SubTypeAwsVpc {
+				info.AwsVpceId = string(value[1:])
+			}
+		case pp2TypeSsl:
+			ssl, err := parseProxyProtocolSSL(value)
+			if err != nil {
+				return err
+			}
+			info.SSL = ssl
+		}
+		// CRC32C, NOOP and custom types are ignored.
+	}
+	return nil
+}
+
+func parseProxyProtocolSSL(b []byte) (*ProxyProtocolSSLInfo, error) {
+	if len(b) < 5 {
+		return nil, errors.New("proxy protocol: short ssl tlv")
+	}
+	client := b[0]
+	ssl := &ProxyProtocolSSLInfo{
+		ClientSSL:      client&pp2ClientSsl != 0,
+		ClientCertConn: client&pp2ClientCertConn != 0,
+		ClientCertSess: client&pp2ClientCertSess != 0,
+		// verify is zero if the client presented a certificate that verified.
+		Verified: binary.BigEndian.Uint32(b[1:5]) == 0,
+	}
+
+	rest := b[5:]
+	for len(rest) > 0 {
+		if len(rest) < 3 {
+			return nil, errors.New("proxy protocol: truncated ssl tlv")
+		}
+		typ := rest[0]
+		n := int(binary.BigEndian.Uint16(rest[1:3]))
+		if len(rest) < 3+n {
+			return nil, errors.New("proxy protocol: truncated ssl tlv")
+		}
+		value := string(rest[3 : 3+n])
+		rest = rest[3+n:]
+
+		switch typ {
+		case pp2SubTypeSslVer:
+			ssl.Version = value
+		case pp2SubTypeSslCn:
+			ssl.CommonName = value
+		case pp2SubTypeCipher:
+			ssl.Cipher = value
+		case pp2SubTypeSigAlg:
+			ssl.SigAlg = value
+		case pp2SubTypeKeyAlg:
+			ssl.KeyAlg = value
+		}
+	}
+	return ssl, nil
+}
+
+type (
+	proxyProtocolConnKey    struct{}
+	proxyProtocolContextKey struct{}
+)
+
+// ProxyProtocolConnContext is an http.Server ConnContext that remembers the
+// connection for ProxyProtocolResolver. It runs on the accept loop, so it
+// must not read from the connection.
+func ProxyProtocolConnContext(ctx context.Context, c net.Conn) context.Context {
+	pc, ok := c.(*proxyProtocolConn)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyProtocolConnKey{}, pc)
+}
+
+// ProxyProtocolResolver makes the PROXY protocol header of the request's
+// connection available to ProxyProtocolFromContext. By the time a request
+// is served, the header has been read by the connection's goroutine.
+func ProxyProtocolResolver(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pc, ok := r.Context().Value(proxyProtocolConnKey{}).(*proxyProtocolConn); ok {
+			pc.readHeader()
+			if pc.info != nil {
+				r = r.WithContext(context.WithValue(r.Context(), proxyProtocolContextKey{}, pc.info))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ProxyProtocolFromContext returns the PROXY protocol header the request's
+// connection started with, if any.
+func ProxyProtocolFromContext(ctx context.Context) (*ProxyProtocolInfo, bool) {
+	info, ok := ctx.Value(proxyProtocolContextKey{}).(*ProxyProtocolInfo)
+	return info, ok
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func ppV2Header(cmd, family byte, body []byte) []byte {
+	h := append([]byte{}, proxyProtocolV2Sig...)
+	h = append(h, 0x20|cmd, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(body)))
+	return append(h, body...)
+}
+
+func ppTLV(typ byte, value []byte) []byte {
+	b := []byte{typ, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func TestReadProxyProtocolV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		source  string
+		wantErr bool
+	}{
+		{name: "tcp4", in: "PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\r\n", source: "203.0.113.7:5555"},
+		{name: "tcp6", in: "PROXY TCP6 2001:db8::1 2001:db8::2 5555 443\r\n", source: "[2001:db8::1]:5555"},
+		{name: "unknown", in: "PROXY UNKNOWN\r\n"},
+		{name: "family mismatch", in: "PROXY TCP4 2001:db8::1 10.0.0.1 5555 443\r\n", wantErr: true},
+		{name: "bad port", in: "PROXY TCP4 203.0.113.7 10.0.0.1 70000 443\r\n", wantErr: true},
+		{name: "missing fields", in: "PROXY TCP4 203.0.113.7\r\n", wantErr: true},
+		{name: "not terminated", in: "PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\n", wantErr: true},
+		{name: "truncated", in: "PROXY TCP4 203.0.113.7 10.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, info, err := readProxyProtocolHeader(bufio.NewReader(bytes.NewReader([]byte(tt.in+"GET / HTTP/1.1\r\n"))), true)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got source %v", src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info == nil || info.Version != 1 {
+				t.Fatalf("info = %+v", info)
+			}
+			got := ""
+			if src != nil {
+				got = src.String()
+			}
+			if got != tt.source {
+				t.Errorf("source = %q, want %q", got, tt.source)
+			}
+		})
+	}
+}
+
+func TestReadProxyProtocolV2(t *testing.T) {
+	v4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x15, 0xb3, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	copy(v6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6[32:], 5555)
+	binary.BigEndian.PutUint16(v6[34:], 443)
+
+	ssl := append([]byte{pp2ClientSsl | pp2ClientCertConn, 0, 0, 0, 0}, ppTLV(pp2SubTypeSslVer, []byte("TLSv1.3"))...)
+	ssl = append(ssl, ppTLV(pp2SubTypeSslCn, []byte("client.example"))...)
+	withTLVs := append(append([]byte{}, v4...), ppTLV(pp2TypeSsl, ssl)...)
+	withTLVs = append(withTLVs, ppTLV(pp2TypeAuthority, []byte("example.com"))...)
+	withTLVs = append(withTLVs, ppTLV(pp2TypeAws, append([]byte{pp2SubTypeAwsVpc}, "vpce-1"...))...)
+
+	tests := []struct {
+		name    string
+		in      []byte
+		source  string
+		check   func(t *testing.T, info *ProxyProtocolInfo)
+		wantErr bool
+	}{
+		{name: "ipv4", in: ppV2Header(0x1, 0x1, v4), source: "203.0.113.7:5555"},
+		{name: "ipv6", in: ppV2Header(0x1, 0x2, v6), source: "[2001:db8::1]:5555"},
+		{name: "local", in: ppV2Header(0x0, 0x1, v4)},
+		{name: "tlvs", in: ppV2Header(0x1, 0x1, withTLVs), source: "203.0.113.7:5555", check: func(t *testing.T, info *ProxyProtocolInfo) {
+			if info.Authority != "example.com" || info.AwsVpceId != "vpce-1" {
+				t.Errorf("info = %+v", info)
+			}
+			if info.SSL == nil || !info.SSL.ClientSSL || !info.SSL.ClientCertConn || info.SSL.ClientCertSess ||
+				!info.SSL.Verified || info.SSL.Version != "TLSv1.3" || info.SSL.CommonName != "client.example" {
+				t.Errorf("ssl = %+v", info.SSL)
+			}
+		}},
+		{name: "unknown command", in: ppV2Header(0x2, 0x1, v4), wantErr: true},
+		{name: "short addresses", in: ppV2Header(0x1, 0x2, v4), wantErr: true},
+		{name: "truncated tlv", in: ppV2Header(0x1, 0x1, append(append([]byte{}, v4...), pp2TypeAuthority, 0, 9, 'x')), wantErr: true},
+		{name: "truncated body", in: ppV2Header(0x1, 0x1, v4)[:20], wantErr: true},
+		{name: "truncated signature", in: proxyProtocolV2Sig[:8], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, info, err := readProxyProtocolHeader(bufio.NewReader(bytes.NewReader(tt.in)), true)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got source %v", src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info == nil || info.Version != 2 {
+				t.Fatalf("info = %+v", info)
+			}
+			got := ""
+			if src != nil {
+				got = src.String()
+			}
+			if got != tt.source {
+				t.Errorf("source = %q, want %q", got, tt.source)
+			}
+			if tt.check != nil {
+				tt.check(t, info)
+			}
+		})
+	}
+}
+
+func TestReadProxyProtocolMissing(t *testing.T) {
+	req := "GET / HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	if _, _, err := readProxyProtocolHeader(bufio.NewReader(bytes.NewReader([]byte(req))), true); !errors.Is(err, ErrProxyProtocolMissing) {
+		t.Errorf("required: err = %v, want ErrProxyProtocolMissing", err)
+	}
+
+	r := bufio.NewReader(bytes.NewReader([]byte(req)))
+	src, info, err := readProxyProtocolHeader(r, false)
+	if err != nil || src != nil || info != nil {
+		t.Fatalf("optional: got %v %v %v", src, info, err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != req {
+		t.Errorf("optional: request consumed, left %q", rest)
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	pl := &ProxyProtocolListener{Listener: ln, Trusted: []*net.IPNet{lo}, Timeout: 200 * time.Millisecond}
+
+	srv := httptest.NewUnstartedServer(ProxyProtocolResolver(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := ProxyProtocolFromContext(r.Context())
+		_, _ = w.Write([]byte(r.RemoteAddr + " " + info.Authority))
+	})))
+	srv.Listener = pl
+	srv.Config.ConnContext = ProxyProtocolConnContext
+	srv.Start()
+	defer srv.Close()
+
+	dial := func(t *testing.T) net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	t.Run("header", func(t *testing.T) {
+		conn := dial(t)
+		defer conn.Close()
+
+		body := append([]byte{203, 0, 113, 7, 10, 0, 0, 1, 0x15, 0xb3, 0x01, 0xbb}, ppTLV(pp2TypeAuthority, []byte("example.com"))...)
+		_, _ = conn.Write(append(ppV2Header(0x1, 0x1, body), "GET / HTTP/1.0\r\n\r\n"...))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		if string(got) != "203.0.113.7:5555 example.com" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("slow peer doesn't block others", func(t *testing.T) {
+		slow := dial(t)
+		defer slow.Close()
+
+		conn := dial(t)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(pl.Timeout / 2))
+		_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.8 10.0.0.1 5555 443\r\nGET / HTTP/1.0\r\n\r\n"))
+		if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+			t.Fatalf("second connection stalled: %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		conn := dial(t)
+		defer conn.Close()
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * pl.Timeout))
+		start := time.Now()
+		_, err := conn.Read(make([]byte, 1))
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("connection wasn't closed after the header timeout")
+		}
+		if elapsed := time.Since(start); elapsed < pl.Timeout {
+			t.Errorf("closed after %s, before the timeout", elapsed)
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		conn := dial(t)
+		defer conn.Close()
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * pl.Timeout))
+		_, _ = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+			t.Error("expected the connection to be closed")
+		}
+	})
+}
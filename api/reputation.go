@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReputationDefaultTimeout = 2 * time.Second
+	reputationCacheSize      = 10000
+	reputationMaxBodyBytes   = 1 << 20
+)
+
+var ErrReputationQuotaExceeded = errors.New("reputation quota exceeded")
+
+type ReputationOptions struct {
+	BaseUrl    string
+	ApiKey     string
+	Timeout    time.Duration
+	CacheTTL   time.Duration
+	DailyQuota int
+	// Fields are dotted paths into the provider response, e.g.
+	// "data.attributes.country", that are copied to the lookup result.
+	Fields []string
+}
+
+// reputationAdapter knows how to query one reputation API and how to map
+// its response.
+type reputationAdapter interface {
+	name() string
+	defaultBaseUrl() string
+	defaultFields() []string
+	newRequest(baseUrl, apiKey string, addr netip.Addr) (*http.Request, error)
+	// parse maps a response into a reputation. It is called for 200 and 404
+	// responses; most providers answer 404 for addresses they know nothing
+	// about.
+	parse(status int, body map[string]any) (reputationVerdict, error)
+}
+
+type reputationVerdict struct {
+	score          int
+	malicious      bool
+	classification string
+	tags           []string
+}
+
+// ReputationProvider enriches lookups with the verdict of a third-party IP
+// reputation API. Answers are cached, and upstream calls are limited to a
+// daily quota and suspended while the provider asks to back off.
+type ReputationProvider struct {
+	adapter    reputationAdapter
+	httpClient *http.Client
+	opts       ReputationOptions
+	cache      *ttlCache[netip.Addr, ProviderReputation]
+
+	mu         sync.Mutex
+	quotaDay   string
+	quotaUsed  int
+	blockedTil time.Time
+}
+
+func newReputationProvider(adapter reputationAdapter, opts ReputationOptions) *ReputationProvider {
+	if opts.BaseUrl == "" {
+		opts.BaseUrl = adapter.defaultBaseUrl()
+	}
+	opts.BaseUrl = strings.TrimSuffix(opts.BaseUrl, "/")
+	if opts.Timeout <= 0 {
+		opts.Timeout = ReputationDefaultTimeout
+	}
+	if len(opts.Fields) == 0 {
+		opts.Fields = adapter.defaultFields()
+	}
+
+	return &ReputationProvider{
+		adapter:    adapter,
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		opts:       opts,
+		cache:      newTTLCache[netip.Addr, ProviderReputation](opts.CacheTTL, reputationCacheSize),
+	}
+}
+
+func (p *ReputationProvider) Name() string {
+	return p.adapter.name()
+}
+
+// NewReputationProvider returns the adapter for a provider by name:
+// ipqualityscore, greynoise, virustotal or shodan.
+func NewReputationProvider(name string, opts ReputationOptions) (*ReputationProvider, error) {
+	var adapter reputationAdapter
+	switch name {
+	case "ipqualityscore":
+		adapter = ipQualityScoreAdapter{}
+	case "greynoise":
+		adapter = greyNoiseAdapter{}
+	case "virustotal":
+		adapter = virusTotalAdapter{}
+	case "shodan":
+		adapter = shodanInternetDbAdapter{}
+	default:
+		return nil, fmt.Errorf("unknown reputation provider %q", name)
+	}
+	return newReputationProvider(adapter, opts), nil
+}
+
+// takeQuota reserves one upstream call, if the quota allows.
+func (p *ReputationProvider) takeQuota(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Before(p.blockedTil) {
+		return false
+	}
+	if p.opts.DailyQuota <= 0 {
+		return true
+	}
+
+	day := now.Format(time.DateOnly)
+	if p.quotaDay != day {
+		p.quotaDay = day
+		p.quotaUsed = 0
+	}
+	if p.quotaUsed >= p.opts.DailyQuota {
+		return false
+	}
+	p.quotaUsed++
+	return true
+}
+
+func (p *ReputationProvider) blockUntil(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t.After(p.blockedTil) {
+		p.blockedTil = t
+	}
+}
+
+// Check returns the provider's reputation of addr, from the cache if
+// possible.
+func (p *ReputationProvider) Check(addr netip.Addr) (ProviderReputation, error) {
+	addr = addr.Unmap()
+	if rep, ok := p.cache.Get(addr); ok {
+		rep.Status = DataCached
+		return rep, nil
+	}
+
+	if !p.takeQuota(time.Now().UTC()) {
+		return ProviderReputation{}, ErrReputationQuotaExceeded
+	}
+
+	req, err := p.adapter.newRequest(p.opts.BaseUrl, p.opts.ApiKey, addr)
+	if err != nil {
+		return ProviderReputation{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "ipquery")
+
+	httpResponse, err := p.httpClient.Do(req)
+	if err != nil {
+		return ProviderReputation{}, err
+	}
+	defer httpResponse.Body.Close()
+
+	switch httpResponse.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+	case http.StatusTooManyRequests:
+		p.blockUntil(retryAfter(httpResponse.Header.Get("Retry-After")))
+		return ProviderReputation{}, ErrReputationQuotaExceeded
+	default:
+		return ProviderReputation{}, fmt.Errorf("http status %d", httpResponse.StatusCode)
+	}
+
+	httpBody, err := io.ReadAll(io.LimitReader(httpResponse.Body, reputationMaxBodyBytes))
+	if err != nil {
+		return ProviderReputation{}, err
+	}
+
+	body := map[string]any{}
+	if len(httpBody) > 0 {
+		if err := json.Unmarshal(httpBody, &body); err != nil {
+			return ProviderReputation{}, err
+		}
+	}
+
+	v, err := p.adapter.parse(httpResponse.StatusCode, body)
+	if err != nil {
+		return ProviderReputation{}, err
+	}
+	rep := ProviderReputation{
+		Provider:       p.adapter.name(),
+		Status:         DataChecked,
+		CheckedAt:      ptr(time.Now().UTC()),
+		Score:          ptr(v.score),
+		Malicious:      ptr(v.malicious),
+		Classification: v.classification,
+		Tags:           v.tags,
+		Fields:         extractFields(body, p.opts.Fields),
+	}
+
+	p.cache.Set(addr, rep)
+	return rep, nil
+}
+
+func (p *ReputationProvider) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+	// Nobody has a reputation for private and special-purpose addresses;
+	// don't spend quota on them.
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return nil
+	}
+
+	rep, err := p.Check(addr)
+	if err != nil {
+		out.Risk.Reputation = append(out.Risk.Reputation, ProviderReputation{
+			Provider: p.adapter.name(),
+			Status:   DataUnavailable,
+			Tags:     []string{},
+		})
+		return fmt.Errorf("%s: %w", p.adapter.name(), err)
+	}
+
+	out.Risk.Reputation = append(out.Risk.Reputation, rep)
+	return nil
+}
+
+// extractFields copies the values at the given dotted paths of body.
+func extractFields(body map[string]any, paths []string) map[string]any {
+	out := map[string]any{}
+	for _, path := range paths {
+		var v any = body
+		for _, key := range strings.Split(path, ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if v != nil {
+			out[path] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func jsonNumber(v any) float64 {
+	n, _ := v.(float64)
+	return n
+}
+
+func jsonBool(v any) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+func jsonString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func jsonStrings(v any) []string {
+	items, _ := v.([]any)
+	out := []string{}
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+)
+
+const GreyNoiseDefaultBaseUrl = "https://api.greynoise.io"
+
+// greyNoiseAdapter queries the GreyNoise community API, which tells
+// internet-wide scanners (noise) and known business services (riot) apart.
+type greyNoiseAdapter struct{}
+
+func (greyNoiseAdapter) name() string           { return "greynoise" }
+func (greyNoiseAdapter) defaultBaseUrl() string { return GreyNoiseDefaultBaseUrl }
+
+func (greyNoiseAdapter) defaultFields() []string {
+	return []string{"name", "last_seen", "link"}
+}
+
+func (greyNoiseAdapter) newRequest(baseUrl, apiKey string, addr netip.Addr) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v3/community/%s", baseUrl, addr), nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set("key", apiKey)
+	}
+	return req, nil
+}
+
+func (greyNoiseAdapter) parse(status int, body map[string]any) (reputationVerdict, error) {
+	// 404 means GreyNoise has not observed the address.
+	if status == http.StatusNotFound {
+		return reputationVerdict{classification: "unknown", tags: []string{}}, nil
+	}
+
+	rep := reputationVerdict{
+		classification: jsonString(body["classification"]),
+		tags:           []string{},
+	}
+	if jsonBool(body["noise"]) {
+		rep.tags = append(rep.tags, "noise")
+	}
+	if jsonBool(body["riot"]) {
+		rep.tags = append(rep.tags, "riot")
+	}
+
+	switch rep.classification {
+	case "malicious":
+		rep.score = 100
+		rep.malicious = true
+	case "suspicious":
+		rep.score = 60
+	case "benign":
+		rep.score = 0
+	default:
+		if jsonBool(body["noise"]) {
+			rep.score = 30
+		}
+	}
+	return rep, nil
+}
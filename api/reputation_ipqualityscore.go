@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+)
+
+const (
+	IpQualityScoreDefaultBaseUrl = "https://ipqualityscore.com/api"
+	ipQualityScoreMalicious      = 85
+)
+
+// ipQualityScoreAdapter queries the IPQualityScore proxy & VPN detection
+// API, whose fraud score is already on a 0-100 scale.
+type ipQualityScoreAdapter struct{}
+
+func (ipQualityScoreAdapter) name() string           { return "ipqualityscore" }
+func (ipQualityScoreAdapter) defaultBaseUrl() string { return IpQualityScoreDefaultBaseUrl }
+
+func (ipQualityScoreAdapter) defaultFields() []string {
+	return []string{"ISP", "connection_type", "abuse_velocity"}
+}
+
+func (ipQualityScoreAdapter) newRequest(baseUrl, apiKey string, addr netip.Addr) (*http.Request, error) {
+	u := fmt.Sprintf("%s/json/ip/%s/%s?strictness=0", baseUrl, url.PathEscape(apiKey), addr)
+	return http.NewRequest(http.MethodGet, u, nil)
+}
+
+func (ipQualityScoreAdapter) parse(status int, body map[string]any) (reputationVerdict, error) {
+	if status != http.StatusOK {
+		return reputationVerdict{}, fmt.Errorf("http status %d", status)
+	}
+	if !jsonBool(body["success"]) {
+		return reputationVerdict{}, fmt.Errorf("request failed: %s", jsonString(body["message"]))
+	}
+
+	score := int(jsonNumber(body["fraud_score"]))
+	rep := reputationVerdict{
+		score:     score,
+		malicious: score >= ipQualityScoreMalicious || jsonBool(body["recent_abuse"]),
+		tags:      []string{},
+	}
+	for _, tag := range []string{"proxy", "vpn", "tor", "recent_abuse", "bot_status"} {
+		if jsonBool(body[tag]) {
+			rep.tags = append(rep.tags, tag)
+		}
+	}
+
+	switch {
+	case rep.malicious:
+		rep.classification = "malicious"
+	case score >= 75:
+		rep.classification = "suspicious"
+	default:
+		rep.classification = "clean"
+	}
+	return rep, nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+const ShodanInternetDbDefaultBaseUrl = "https://internetdb.shodan.io"
+
+// shodanInternetDbAdapter queries Shodan InternetDB, a free, keyless summary
+// of open ports, known vulnerabilities and tags of an address.
+type shodanInternetDbAdapter struct{}
+
+func (shodanInternetDbAdapter) name() string           { return "shodan" }
+func (shodanInternetDbAdapter) defaultBaseUrl() string { return ShodanInternetDbDefaultBaseUrl }
+
+func (shodanInternetDbAdapter) defaultFields() []string {
+	return []string{"ports", "hostnames", "vulns"}
+}
+
+func (shodanInternetDbAdapter) newRequest(baseUrl, _ string, addr netip.Addr) (*http.Request, error) {
+	return http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", baseUrl, addr), nil)
+}
+
+func (shodanInternetDbAdapter) parse(status int, body map[string]any) (reputationVerdict, error) {
+	if status == http.StatusNotFound {
+		return reputationVerdict{classification: "unknown", tags: []string{}}, nil
+	}
+
+	rep := reputationVerdict{tags: jsonStrings(body["tags"]), classification: "observed"}
+	has := func(tags ...string) bool {
+		for _, t := range tags {
+			if slices.Contains(rep.tags, t) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("malware", "compromised"):
+		rep.score = 100
+		rep.malicious = true
+		rep.classification = "malicious"
+	case has("scanner"):
+		rep.score = 60
+		rep.classification = "suspicious"
+	case has("proxy", "vpn", "tor"):
+		rep.score = 40
+	default:
+		rep.score = min(len(jsonStrings(body["vulns"]))*5, 50)
+	}
+	return rep, nil
+}
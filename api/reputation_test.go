@@ -0,0 +1,270 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var reputationTestAddr = netip.MustParseAddr("203.0.113.7")
+
+// reputationStandIn serves body with status for every request to path and
+// counts the requests.
+func reputationStandIn(t *testing.T, path string, status int, body string, check func(*http.Request) bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != path {
+			t.Errorf("path = %s, want %s", r.URL.Path, path)
+		}
+		if check != nil && !check(r) {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "3600")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+type reputationAdapterTest struct {
+	provider string
+	path     string
+	// check validates the request details specific to the provider.
+	check func(*http.Request) bool
+	body  string
+	// notFound is whether a 404 is a valid "unknown" answer.
+	notFound bool
+
+	score          int
+	malicious      bool
+	classification string
+	tags           []string
+	fields         map[string]any
+}
+
+var reputationAdapterTests = []reputationAdapterTest{
+	{
+		provider: "ipqualityscore",
+		path:     "/json/ip/secret/203.0.113.7",
+		check: func(r *http.Request) bool {
+			return r.URL.Query().Get("strictness") == "0"
+		},
+		body:           `{"success": true, "fraud_score": 88, "proxy": true, "vpn": true, "tor": false, "recent_abuse": false, "ISP": "Example ISP", "connection_type": "Data Center"}`,
+		score:          88,
+		malicious:      true,
+		classification: "malicious",
+		tags:           []string{"proxy", "vpn"},
+		fields:         map[string]any{"ISP": "Example ISP", "connection_type": "Data Center"},
+	},
+	{
+		provider: "greynoise",
+		path:     "/v3/community/203.0.113.7",
+		check: func(r *http.Request) bool {
+			return r.Header.Get("key") == "secret"
+		},
+		body:           `{"ip": "203.0.113.7", "noise": true, "riot": false, "classification": "suspicious", "name": "unknown", "link": "https://viz.greynoise.io/ip/203.0.113.7"}`,
+		notFound:       true,
+		score:          60,
+		classification: "suspicious",
+		tags:           []string{"noise"},
+		fields:         map[string]any{"name": "unknown", "link": "https://viz.greynoise.io/ip/203.0.113.7"},
+	},
+	{
+		provider: "virustotal",
+		path:     "/ip_addresses/203.0.113.7",
+		check: func(r *http.Request) bool {
+			return r.Header.Get("x-apikey") == "secret"
+		},
+		body:           `{"data": {"attributes": {"last_analysis_stats": {"malicious": 3, "suspicious": 1, "harmless": 60}, "tags": ["scanner"], "reputation": -12, "country": "NL"}}}`,
+		notFound:       true,
+		score:          35,
+		malicious:      true,
+		classification: "malicious",
+		tags:           []string{"scanner"},
+		fields:         map[string]any{"data.attributes.reputation": float64(-12), "data.attributes.country": "NL"},
+	},
+	{
+		provider:       "shodan",
+		path:           "/203.0.113.7",
+		body:           `{"ip": "203.0.113.7", "ports": [22, 80], "tags": ["scanner"], "vulns": [], "hostnames": []}`,
+		notFound:       true,
+		score:          60,
+		classification: "suspicious",
+		tags:           []string{"scanner"},
+		fields:         map[string]any{"ports": []any{float64(22), float64(80)}, "hostnames": []any{}, "vulns": []any{}},
+	},
+}
+
+func newTestReputationProvider(t *testing.T, provider, baseUrl string, quota int) *ReputationProvider {
+	t.Helper()
+
+	p, err := NewReputationProvider(provider, ReputationOptions{BaseUrl: baseUrl, ApiKey: "secret", CacheTTL: time.Minute, DailyQuota: quota})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReputationAdapters(t *testing.T) {
+	for _, tt := range reputationAdapterTests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, _ := reputationStandIn(t, tt.path, http.StatusOK, tt.body, tt.check)
+			p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+			rep, err := p.Check(reputationTestAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rep.Provider != tt.provider || rep.Status != DataChecked || rep.CheckedAt == nil {
+				t.Errorf("provider = %s, status = %s, checked_at = %v", rep.Provider, rep.Status, rep.CheckedAt)
+			}
+			if deref(rep.Score) != tt.score || deref(rep.Malicious) != tt.malicious {
+				t.Errorf("score = %v, malicious = %v, want %d, %v", rep.Score, rep.Malicious, tt.score, tt.malicious)
+			}
+			if rep.Classification != tt.classification {
+				t.Errorf("classification = %q, want %q", rep.Classification, tt.classification)
+			}
+			if !slices.Equal(rep.Tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", rep.Tags, tt.tags)
+			}
+			for k, want := range tt.fields {
+				if got, ok := rep.Fields[k]; !ok || !jsonEqual(got, want) {
+					t.Errorf("fields[%s] = %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReputationStatus(t *testing.T) {
+	for _, tt := range reputationAdapterTests {
+		t.Run(tt.provider+"/404", func(t *testing.T) {
+			srv, _ := reputationStandIn(t, tt.path, http.StatusNotFound, `{}`, nil)
+			p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+			rep, err := p.Check(reputationTestAddr)
+			if !tt.notFound {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", rep)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Classification != "unknown" || deref(rep.Score) != 0 || deref(rep.Malicious) {
+				t.Errorf("got %+v, want an unknown, clean verdict", rep)
+			}
+		})
+
+		t.Run(tt.provider+"/429", func(t *testing.T) {
+			srv, calls := reputationStandIn(t, tt.path, http.StatusTooManyRequests, `{}`, nil)
+			p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+			if _, err := p.Check(reputationTestAddr); !errors.Is(err, ErrReputationQuotaExceeded) {
+				t.Errorf("err = %v, want ErrReputationQuotaExceeded", err)
+			}
+			// Backing off: the provider isn't asked again.
+			if _, err := p.Check(netip.MustParseAddr("203.0.113.8")); !errors.Is(err, ErrReputationQuotaExceeded) {
+				t.Errorf("second check: err = %v, want ErrReputationQuotaExceeded", err)
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("upstream calls = %d, want 1", n)
+			}
+		})
+
+		t.Run(tt.provider+"/5xx", func(t *testing.T) {
+			srv, _ := reputationStandIn(t, tt.path, http.StatusBadGateway, `upstream down`, nil)
+			p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+			var res LookupResult
+			if err := p.Enrich(reputationTestAddr.AsSlice(), &res); err == nil {
+				t.Error("expected an error")
+			}
+			if len(res.Risk.Reputation) != 1 || res.Risk.Reputation[0].Status != DataUnavailable || res.Risk.Reputation[0].Score != nil {
+				t.Errorf("reputation = %+v, want one unavailable entry", res.Risk.Reputation)
+			}
+		})
+	}
+}
+
+func TestReputationCached(t *testing.T) {
+	tt := reputationAdapterTests[3]
+	srv, calls := reputationStandIn(t, tt.path, http.StatusOK, tt.body, nil)
+	p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+	first, err := p.Check(reputationTestAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Check(reputationTestAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Status != DataChecked || second.Status != DataCached {
+		t.Errorf("status = %s, then %s, want checked, then cached", first.Status, second.Status)
+	}
+	if deref(second.Score) != deref(first.Score) {
+		t.Errorf("cached score = %v, want %v", second.Score, first.Score)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestReputationDailyQuota(t *testing.T) {
+	tt := reputationAdapterTests[3]
+	srv, calls := reputationStandIn(t, tt.path, http.StatusOK, tt.body, nil)
+	p := newTestReputationProvider(t, tt.provider, srv.URL, 1)
+
+	if _, err := p.Check(reputationTestAddr); err != nil {
+		t.Fatal(err)
+	}
+	// Cached answers don't count against the quota.
+	if _, err := p.Check(reputationTestAddr); err != nil {
+		t.Errorf("cached check: %v", err)
+	}
+	if _, err := p.Check(netip.MustParseAddr("203.0.113.8")); !errors.Is(err, ErrReputationQuotaExceeded) {
+		t.Errorf("err = %v, want ErrReputationQuotaExceeded", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestReputationSkipsNonGlobal(t *testing.T) {
+	tt := reputationAdapterTests[3]
+	srv, calls := reputationStandIn(t, tt.path, http.StatusOK, tt.body, nil)
+	p := newTestReputationProvider(t, tt.provider, srv.URL, 0)
+
+	var res LookupResult
+	if err := p.Enrich(netip.MustParseAddr("10.0.0.1").AsSlice(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Risk.Reputation) != 0 || calls.Load() != 0 {
+		t.Errorf("private address was checked: %+v", res.Risk.Reputation)
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	as, aok := a.([]any)
+	bs, bok := b.([]any)
+	if aok && bok {
+		return slices.EqualFunc(as, bs, jsonEqual)
+	}
+	return a == b
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+)
+
+const (
+	VirusTotalDefaultBaseUrl = "https://www.virustotal.com/api/v3"
+	virusTotalMalicious      = 2
+)
+
+// virusTotalAdapter queries the VirusTotal IP address report, which
+// aggregates the verdicts of many security vendors.
+type virusTotalAdapter struct{}
+
+func (virusTotalAdapter) name() string           { return "virustotal" }
+func (virusTotalAdapter) defaultBaseUrl() string { return VirusTotalDefaultBaseUrl }
+
+func (virusTotalAdapter) defaultFields() []string {
+	return []string{"data.attributes.reputation", "data.attributes.as_owner", "data.attributes.country"}
+}
+
+func (virusTotalAdapter) newRequest(baseUrl, apiKey string, addr netip.Addr) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/ip_addresses/%s", baseUrl, addr), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-apikey", apiKey)
+	return req, nil
+}
+
+func (virusTotalAdapter) parse(status int, body map[string]any) (reputationVerdict, error) {
+	if status == http.StatusNotFound {
+		return reputationVerdict{classification: "unknown", tags: []string{}}, nil
+	}
+
+	data, _ := body["data"].(map[string]any)
+	attrs, _ := data["attributes"].(map[string]any)
+	stats, _ := attrs["last_analysis_stats"].(map[string]any)
+
+	malicious := int(jsonNumber(stats["malicious"]))
+	suspicious := int(jsonNumber(stats["suspicious"]))
+
+	// A handful of vendors flagging an address is already significant, so
+	// every verdict weighs heavily rather than being a share of all vendors.
+	rep := reputationVerdict{
+		score:     int(math.Min(float64(malicious*10+suspicious*5), 100)),
+		malicious: malicious >= virusTotalMalicious,
+		tags:      jsonStrings(attrs["tags"]),
+	}
+
+	switch {
+	case malicious > 0:
+		rep.classification = "malicious"
+	case suspicious > 0:
+		rep.classification = "suspicious"
+	default:
+		rep.classification = "harmless"
+	}
+	return rep, nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	RiskFactorAbuse      = "abuse_confidence"
+	RiskFactorTor        = "tor"
+	RiskFactorHosting    = "hosting"
+	RiskFactorBlocklist  = "blocklist"
+	RiskFactorAnonymous  = "anonymous"
+	RiskFactorRecency    = "recent_reports"
+	RiskFactorReputation = "reputation"
+	RiskFactorAllowlist  = "allowlist"
+	RiskFactorDenylist   = "denylist"
+)
+
+// RiskWeights are the maximum points each signal adds to the 0-100 score.
+// Their defaults are those of the RISK_WEIGHT_* settings.
+type RiskWeights struct {
+	Abuse         float64
+	Tor           float64
+	Hosting       float64
+	BlocklistHit  float64
+	BlocklistMax  float64
+	Anonymous     float64
+	Recency       float64
+	RecencyWindow time.Duration
+	Reputation    float64
+}
+
+type RiskScorer struct {
+	weights RiskWeights
+}
+
+func NewRiskScorer(weights RiskWeights) *RiskScorer {
+	return &RiskScorer{weights: weights}
+}
+
+// Score computes ipquery's own risk score from the signals other enrichers
+// put into res, and explains it with the factors that contributed.
+func (s *RiskScorer) Score(res *LookupResult) {
+	w := s.weights
+	reasons := []RiskReason{}
+
+	add := func(factor string, weight, points float64, detail string) {
+		if points <= 0 {
+			return
+		}
+		reasons = append(reasons, RiskReason{
+			Factor: factor,
+			Weight: weight,
+			Points: math.Round(points*10) / 10,
+			Detail: detail,
+		})
+	}
+
+	if score := deref(res.Risk.AbuseConfidenceScore); score > 0 {
+		add(RiskFactorAbuse, w.Abuse, w.Abuse*float64(score)/100,
+			fmt.Sprintf("AbuseIPDB confidence score %d", score))
+	}
+
+	if deref(res.Risk.IsTor) {
+		add(RiskFactorTor, w.Tor, w.Tor, "Tor exit node")
+	}
+
+	if detail, ok := hostingSignal(res); ok {
+		add(RiskFactorHosting, w.Hosting, w.Hosting, detail)
+	}
+
+	if hits := blocklistHits(res); len(hits) > 0 {
+		add(RiskFactorBlocklist, w.BlocklistMax, math.Min(w.BlocklistHit*float64(len(hits)), w.BlocklistMax),
+			"listed on "+strings.Join(hits, ", "))
+	}
+
+	if detail, ok := anonymousSignal(res); ok {
+		add(RiskFactorAnonymous, w.Anonymous, w.Anonymous, detail)
+	}
+
+	if deref(res.Risk.TotalReports) > 0 && res.Risk.LastReportedAt != nil && w.RecencyWindow > 0 {
+		age := time.Since(*res.Risk.LastReportedAt)
+		if age < w.RecencyWindow {
+			if age < 0 {
+				age = 0
+			}
+			recency := 1 - float64(age)/float64(w.RecencyWindow)
+			add(RiskFactorRecency, w.Recency, w.Recency*recency,
+				fmt.Sprintf("last reported %s ago", age.Round(time.Hour)))
+		}
+	}
+
+	if top, ok := topReputation(res); ok {
+		add(RiskFactorReputation, w.Reputation, w.Reputation*float64(*top.Score)/100,
+			fmt.Sprintf("%s score %d", top.Provider, *top.Score))
+	}
+
+	total := 0.0
+	for _, r := range reasons {
+		total += r.Points
+	}
+
+	res.Risk.Reasons = reasons
+	res.Risk.Score = nil
+	if len(reasons) > 0 || riskChecked(res) {
+		res.Risk.Score = ptr(int(math.Round(math.Min(total, 100))))
+	}
+
+	// An operator's own list overrides every other signal. The first
+	// match in ManagedLists.Match's order of precedence decides.
+	if len(res.Risk.ManagedLists) > 0 {
+		m := res.Risk.ManagedLists[0]
+		detail := fmt.Sprintf("%s on managed list %s", m.Entry, m.List)
+		switch m.Kind {
+		case ManagedListDeny:
+			res.Risk.Score = ptr(100)
+			res.Risk.Reasons = []RiskReason{{Factor: RiskFactorDenylist, Weight: 100, Points: 100, Detail: detail}}
+		case ManagedListAllow:
+			res.Risk.Score = ptr(0)
+			res.Risk.Reasons = []RiskReason{{Factor: RiskFactorAllowlist, Detail: detail}}
+		}
+	}
+}
+
+// riskChecked reports whether any risk source answered for the address, so
+// that a score of 0 means clean rather than not checked at all.
+func riskChecked(res *LookupResult) bool {
+	if res.Risk.Status == DataChecked || res.Risk.Status == DataCached {
+		return true
+	}
+	if res.Risk.IsTor != nil || res.Risk.Lists != nil || len(res.Risk.ManagedLists) > 0 {
+		return true
+	}
+	if res.Risk.DnsblStatus == DataChecked || res.Risk.DnsblStatus == DataCached {
+		return true
+	}
+	for _, r := range res.Risk.Reputation {
+		if r.Status == DataChecked || r.Status == DataCached {
+			return true
+		}
+	}
+	return false
+}
+
+// topReputation returns the most severe third-party reputation verdict.
+func topReputation(res *LookupResult) (ProviderReputation, bool) {
+	var top ProviderReputation
+	for _, r := range res.Risk.Reputation {
+		if deref(r.Score) > deref(top.Score) {
+			top = r
+		}
+	}
+	return top, deref(top.Score) > 0
+}
+
+func hostingSignal(res *LookupResult) (string, bool) {
+	if res.Hosting != nil {
+		return "hosted at " + res.Hosting.Provider, true
+	}
+	if res.ISP.IsHosting {
+		return "hosting network " + res.ISP.ASN, true
+	}
+	if usageType := deref(res.Risk.UsageType); strings.Contains(usageType, "Data Center") {
+		return "usage type " + usageType, true
+	}
+	return "", false
+}
+
+func blocklistHits(res *LookupResult) []string {
+	hits := append([]string{}, res.Risk.Lists...)
+	for _, l := range res.Risk.Dnsbl {
+		hits = append(hits, l.List)
+	}
+	return hits
+}
+
+// anonymousSignal looks for proxy and VPN indications in the network
+// classification, the AbuseIPDB report categories and the DNSBL reasons.
+func anonymousSignal(res *LookupResult) (string, bool) {
+	if res.ISP.IsVpn {
+		return "VPN provider " + res.ISP.ASN, true
+	}
+	if res.Risk.Reports != nil {
+		for _, c := range res.Risk.Reports.Categories {
+			if c.ID == 9 || c.ID == 13 {
+				return "reported as " + c.Name, true
+			}
+		}
+	}
+	for _, l := range res.Risk.Dnsbl {
+		for _, reason := range l.Reasons {
+			if strings.Contains(strings.ToLower(reason), "proxy") {
+				return l.List + " " + reason, true
+			}
+		}
+	}
+	return "", false
+}
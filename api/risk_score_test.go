@@ -0,0 +1,51 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRiskScoreUnchecked(t *testing.T) {
+	s := NewRiskScorer(RiskWeights{Abuse: 50, Tor: 30, Hosting: 15, BlocklistHit: 20, BlocklistMax: 40})
+
+	tests := []struct {
+		name string
+		risk RiskInfo
+		want *int
+	}{
+		{name: "disabled", risk: RiskInfo{Status: DataDisabled}},
+		{name: "unavailable", risk: RiskInfo{Status: DataUnavailable}},
+		{name: "provider unavailable", risk: RiskInfo{Status: DataDisabled, Reputation: []ProviderReputation{{Provider: "greynoise", Status: DataUnavailable}}}},
+		{name: "checked clean", risk: RiskInfo{Status: DataChecked, AbuseConfidenceScore: ptr(0)}, want: ptr(0)},
+		{name: "tor list clean", risk: RiskInfo{Status: DataDisabled, IsTor: ptr(false)}, want: ptr(0)},
+		{name: "blocklists clean", risk: RiskInfo{Status: DataDisabled, Lists: []string{}}, want: ptr(0)},
+		{name: "tor exit", risk: RiskInfo{Status: DataDisabled, IsTor: ptr(true)}, want: ptr(30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := LookupResult{Risk: tt.risk}
+			s.Score(&res)
+
+			switch {
+			case tt.want == nil && res.Risk.Score != nil:
+				t.Errorf("score = %d, want null", *res.Risk.Score)
+			case tt.want != nil && (res.Risk.Score == nil || *res.Risk.Score != *tt.want):
+				t.Errorf("score = %v, want %d", res.Risk.Score, *tt.want)
+			}
+			if res.Risk.Status != tt.risk.Status {
+				t.Errorf("status = %s, want %s", res.Risk.Status, tt.risk.Status)
+			}
+		})
+	}
+}
+
+func TestRiskScoreSignalWithoutSource(t *testing.T) {
+	s := NewRiskScorer(RiskWeights{Hosting: 15, RecencyWindow: time.Hour})
+
+	// A hosting range is a signal of its own even if no risk source answered.
+	res := LookupResult{Risk: RiskInfo{Status: DataDisabled}, Hosting: &HostingInfo{Provider: "aws"}}
+	s.Score(&res)
+	if res.Risk.Score == nil || *res.Risk.Score != 15 {
+		t.Errorf("score = %v, want 15", res.Risk.Score)
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	SightingsDefaultRetention  = 30 * 24 * time.Hour
+	SightingsDefaultMaxEntries = 100000
+	sightingsMaxUserAgents     = 10
+	sightingsMaxEndpoints      = 20
+	sightingsMaxUserAgentLen   = 256
+)
+
+type SightingsOptions struct {
+	Path       string
+	Retention  time.Duration
+	MaxEntries int
+}
+
+// sightingEntry is an element of SightingsStore.recent.
+type sightingEntry struct {
+	addr netip.Addr
+	*LocalSighting
+}
+
+// SightingsStore remembers which client IPs were seen, when, how often and
+// with which user agents and endpoints. It lives in memory and is written
+// to a JSON file periodically, so it survives restarts without needing an
+// external database.
+type SightingsStore struct {
+	opts SightingsOptions
+
+	mu      sync.RWMutex
+	entries map[netip.Addr]*list.Element
+	// recent orders the entries by LastSeen, most recent first, so that
+	// eviction and pruning take them off the back.
+	recent *list.List
+	dirty  bool
+
+	loop refreshLoop
+}
+
+func NewSightingsStore(opts SightingsOptions) (*SightingsStore, error) {
+	if opts.Retention <= 0 {
+		opts.Retention = SightingsDefaultRetention
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = SightingsDefaultMaxEntries
+	}
+
+	s := &SightingsStore{opts: opts, entries: map[netip.Addr]*list.Element{}, recent: list.New()}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SightingsStore) load() error {
+	data, err := os.ReadFile(s.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored map[string]*LocalSighting
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("decode %s: %w", s.opts.Path, err)
+	}
+
+	loaded := make([]sightingEntry, 0, len(stored))
+	for k, v := range stored {
+		addr, err := netip.ParseAddr(k)
+		if err != nil || v == nil {
+			continue
+		}
+		loaded = append(loaded, sightingEntry{addr: addr.Unmap(), LocalSighting: v})
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].LastSeen.After(loaded[j].LastSeen) })
+	for _, e := range loaded {
+		if _, ok := s.entries[e.addr]; !ok && len(s.entries) < s.opts.MaxEntries {
+			s.entries[e.addr] = s.recent.PushBack(e)
+		}
+	}
+	s.prune(time.Now().UTC())
+
+	log.Printf("sightings loaded: %d addresses", len(s.entries))
+	return nil
+}
+
+// Start persists the store every interval.
+func (s *SightingsStore) Start(interval time.Duration) {
+	s.loop.start(interval, func() {
+		if err := s.Flush(); err != nil {
+			log.Printf("sightings flush: %v", err)
+		}
+	})
+}
+
+func (s *SightingsStore) Close() error {
+	s.loop.stop()
+	return s.Flush()
+}
+
+// Flush drops expired entries and writes the store to disk if it changed.
+func (s *SightingsStore) Flush() error {
+	s.mu.Lock()
+	s.prune(time.Now().UTC())
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	stored := make(map[string]*LocalSighting, len(s.entries))
+	for k, el := range s.entries {
+		c := el.Value.(sightingEntry).clone()
+		stored[k.String()] = &c
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	data, err := json.Marshal(stored)
+	if err == nil {
+		err = writeFileAtomic(s.opts.Path, data)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// prune drops entries older than the retention. Callers hold s.mu.
+func (s *SightingsStore) prune(now time.Time) {
+	for el := s.recent.Back(); el != nil && now.Sub(el.Value.(sightingEntry).LastSeen) > s.opts.Retention; el = s.recent.Back() {
+		s.remove(el)
+	}
+}
+
+// evictOldest makes room for a new entry. Callers hold s.mu.
+func (s *SightingsStore) evictOldest() {
+	if el := s.recent.Back(); el != nil {
+		s.remove(el)
+	}
+}
+
+// remove drops an entry. Callers hold s.mu.
+func (s *SightingsStore) remove(el *list.Element) {
+	delete(s.entries, el.Value.(sightingEntry).addr)
+	s.recent.Remove(el)
+	s.dirty = true
+}
+
+func (s *SightingsStore) Record(addr netip.Addr, userAgent, endpoint string) {
+	addr = addr.Unmap()
+	now := time.Now().UTC()
+
+	if len(userAgent) > sightingsMaxUserAgentLen {
+		userAgent = userAgent[:sightingsMaxUserAgentLen]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[addr]
+	if ok {
+		s.recent.MoveToFront(el)
+	} else {
+		if len(s.entries) >= s.opts.MaxEntries {
+			s.evictOldest()
+		}
+		el = s.recent.PushFront(sightingEntry{addr: addr, LocalSighting: &LocalSighting{
+			FirstSeen:  now,
+			UserAgents: map[string]int64{},
+			Endpoints:  map[string]int64{},
+		}})
+		s.entries[addr] = el
+	}
+
+	e := el.Value.(sightingEntry)
+	e.LastSeen = now
+	e.Requests++
+	incrementBounded(e.UserAgents, userAgent, sightingsMaxUserAgents)
+	incrementBounded(e.Endpoints, endpoint, sightingsMaxEndpoints)
+	s.dirty = true
+}
+
+// incrementBounded counts key in m, but only adds new keys while m holds
+// fewer than max keys.
+func incrementBounded(m map[string]int64, key string, max int) {
+	if key == "" {
+		return
+	}
+	if _, ok := m[key]; ok || len(m) < max {
+		m[key]++
+	}
+}
+
+func (s *SightingsStore) Get(addr netip.Addr) (LocalSighting, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	el, ok := s.entries[addr.Unmap()]
+	if !ok {
+		return LocalSighting{}, false
+	}
+	return el.Value.(sightingEntry).clone(), true
+}
+
+func (l *LocalSighting) clone() LocalSighting {
+	c := *l
+	c.UserAgents = make(map[string]int64, len(l.UserAgents))
+	for k, v := range l.UserAgents {
+		c.UserAgents[k] = v
+	}
+	c.Endpoints = make(map[string]int64, len(l.Endpoints))
+	for k, v := range l.Endpoints {
+		c.Endpoints[k] = v
+	}
+	return c
+}
+
+// SightingsRecorder records the client IP, user agent and route of every
+// request except health checks.
+func SightingsRecorder(store *SightingsStore, getClientIp ClientIpFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			if r.URL.Path == "/health" {
+				return
+			}
+
+			addr, err := netip.ParseAddr(getClientIp(r))
+			if err != nil {
+				return
+			}
+
+			// Prefer the route pattern so that e.g. every /lookup/{ip} counts
+			// as one endpoint.
+			endpoint := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				endpoint = rctx.RoutePattern()
+			}
+
+			store.Record(addr, r.UserAgent(), r.Method+" "+endpoint)
+		})
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSightingsStoreEviction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sightings.json")
+	s, err := NewSightingsStore(SightingsOptions{Path: path, MaxEntries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := netip.MustParseAddr("203.0.113.1")
+	b := netip.MustParseAddr("203.0.113.2")
+	c := netip.MustParseAddr("203.0.113.3")
+	d := netip.MustParseAddr("203.0.113.4")
+	for _, addr := range []netip.Addr{a, b, c} {
+		s.Record(addr, "curl/8", "GET /own")
+	}
+	// Seeing a again makes b the least recently seen.
+	s.Record(a, "curl/8", "GET /own")
+	s.Record(d, "curl/8", "GET /own")
+
+	if _, ok := s.Get(b); ok {
+		t.Error("least recently seen entry wasn't evicted")
+	}
+	for _, addr := range []netip.Addr{a, c, d} {
+		if _, ok := s.Get(addr); !ok {
+			t.Errorf("%s evicted", addr)
+		}
+	}
+	if e, _ := s.Get(a); e.Requests != 2 || e.UserAgents["curl/8"] != 2 {
+		t.Errorf("a = %+v", e)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloaded with a smaller limit, the most recently seen are kept.
+	s, err = NewSightingsStore(SightingsOptions{Path: path, MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(c); ok {
+		t.Error("reload kept the least recently seen entry")
+	}
+	if _, ok := s.Get(d); !ok {
+		t.Error("reload dropped the most recently seen entry")
+	}
+}
+
+func TestSightingsStorePrune(t *testing.T) {
+	s, err := NewSightingsStore(SightingsOptions{Path: filepath.Join(t.TempDir(), "sightings.json"), Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := netip.MustParseAddr("198.51.100.1")
+	b := netip.MustParseAddr("198.51.100.2")
+	s.Record(a, "", "")
+	s.Record(b, "", "")
+
+	s.prune(time.Now().UTC().Add(time.Hour + time.Second))
+	if len(s.entries) != 0 || s.recent.Len() != 0 {
+		t.Errorf("expired entries kept: %d", len(s.entries))
+	}
+}
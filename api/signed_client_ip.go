@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a signed client address: the address, the unix time it was
+// signed at, and the hex HMAC-SHA256 of
+// "<address>\n<timestamp>\n<method>\n<host>\n<path>", which binds it to the
+// request it came with.
+const (
+	SignedClientIPHeader  = "X-Ipquery-Client-Ip"
+	SignedTimestampHeader = "X-Ipquery-Timestamp"
+	SignedSignatureHeader = "X-Ipquery-Signature"
+
+	signedClientIPMinSecretLen = 16
+)
+
+var (
+	ErrSignatureMissing = errors.New("signed client ip headers missing")
+	ErrSignatureInvalid = errors.New("signed client ip signature invalid")
+	ErrSignatureExpired = errors.New("signed client ip timestamp expired")
+)
+
+// SignedClientIP verifies client addresses signed by an upstream proxy under
+// a shared secret. Unlike forwarding headers, they are accepted from any
+// peer, which suits tunnels whose source addresses are unstable or shared.
+//
+// A signature covers the method, host and path of the request, so it can't
+// be reused for other requests. The same request can still be replayed
+// until the timestamp expires, which maxAge bounds.
+type SignedClientIP struct {
+	secrets [][]byte
+	maxAge  time.Duration
+}
+
+// NewSignedClientIP accepts signatures under any of secrets, so that a new
+// secret can be rolled out before the old one is retired. Timestamps may
+// be off by maxAge in either direction.
+func NewSignedClientIP(secrets []string, maxAge time.Duration) (*SignedClientIP, error) {
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("max age %s: must be positive", maxAge)
+	}
+
+	s := &SignedClientIP{maxAge: maxAge}
+	for _, secret := range secrets {
+		if len(secret) < signedClientIPMinSecretLen {
+			return nil, fmt.Errorf("secret must be at least %d bytes", signedClientIPMinSecretLen)
+		}
+		s.secrets = append(s.secrets, []byte(secret))
+	}
+	if len(s.secrets) == 0 {
+		return nil, errors.New("no secret")
+	}
+	return s, nil
+}
+
+// Sign returns the signature of addr for r at t under the first secret, as a
+// proxy would send it along with r.
+func (s *SignedClientIP) Sign(addr netip.Addr, t time.Time, r *http.Request) (timestamp, signature string) {
+	timestamp = strconv.FormatInt(t.Unix(), 10)
+	return timestamp, hex.EncodeToString(signedClientIPMac(s.secrets[0], addr.String(), timestamp, r))
+}
+
+// Verify returns the signed client address of r, if its signature verifies
+// under one of the secrets and its timestamp is fresh.
+func (s *SignedClientIP) Verify(r *http.Request) (netip.Addr, error) {
+	ipStr := strings.TrimSpace(r.Header.Get(SignedClientIPHeader))
+	timestamp := strings.TrimSpace(r.Header.Get(SignedTimestampHeader))
+	signature := strings.TrimSpace(r.Header.Get(SignedSignatureHeader))
+	if ipStr == "" && timestamp == "" && signature == "" {
+		return netip.Addr{}, ErrSignatureMissing
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != sha256.Size {
+		return netip.Addr{}, ErrSignatureInvalid
+	}
+
+	valid := false
+	for _, secret := range s.secrets {
+		if hmac.Equal(sig, signedClientIPMac(secret, ipStr, timestamp, r)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return netip.Addr{}, ErrSignatureInvalid
+	}
+
+	// Checked after the signature, so that a forged timestamp is reported
+	// as invalid rather than expired.
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return netip.Addr{}, ErrSignatureInvalid
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > s.maxAge || age < -s.maxAge {
+		return netip.Addr{}, ErrSignatureExpired
+	}
+
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, ErrSignatureInvalid
+	}
+	return addr.Unmap(), nil
+}
+
+func signedClientIPMac(secret []byte, ip, timestamp string, r *http.Request) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{ip, timestamp, r.Method, strings.ToLower(r.Host), r.URL.EscapedPath()}, "\n")))
+	return mac.Sum(nil)
+}
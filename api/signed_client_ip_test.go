@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSignedClientIP(t *testing.T) {
+	s, err := NewSignedClientIP([]string{"0123456789abcdef", "fedcba9876543210"}, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := netip.MustParseAddr("203.0.113.7")
+
+	signed := func(method, target string, at time.Time) (ts, sig string) {
+		return s.Sign(client, at, httptest.NewRequest(method, target, nil))
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		ip      string
+		ts, sig string
+		wantErr error
+	}{
+		{name: "valid", method: "GET", target: "http://ipquery.example/own/all"},
+		{name: "query ignored", method: "GET", target: "http://ipquery.example/own/all?x=1"},
+		{name: "host case ignored", method: "GET", target: "http://IPQUERY.example/own/all"},
+		{name: "other path", method: "GET", target: "http://ipquery.example/lookup/1.1.1.1", wantErr: ErrSignatureInvalid},
+		{name: "other host", method: "GET", target: "http://evil.example/own/all", wantErr: ErrSignatureInvalid},
+		{name: "other method", method: "POST", target: "http://ipquery.example/own/all", wantErr: ErrSignatureInvalid},
+		{name: "other ip", method: "GET", target: "http://ipquery.example/own/all", ip: "203.0.113.8", wantErr: ErrSignatureInvalid},
+		{name: "expired", method: "GET", target: "http://ipquery.example/own/all", wantErr: ErrSignatureExpired},
+		{name: "bad hex", method: "GET", target: "http://ipquery.example/own/all", sig: "zz", wantErr: ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := time.Now()
+			if tt.wantErr == ErrSignatureExpired {
+				at = at.Add(-time.Minute)
+			}
+			ts, sig := signed("GET", "http://ipquery.example/own/all", at)
+			if tt.sig != "" {
+				sig = tt.sig
+			}
+			ip := client.String()
+			if tt.ip != "" {
+				ip = tt.ip
+			}
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Header.Set(SignedClientIPHeader, ip)
+			r.Header.Set(SignedTimestampHeader, ts)
+			r.Header.Set(SignedSignatureHeader, sig)
+
+			got, err := s.Verify(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != client {
+				t.Errorf("addr = %s", got)
+			}
+		})
+	}
+
+	if _, err := s.Verify(httptest.NewRequest("GET", "/own", nil)); !errors.Is(err, ErrSignatureMissing) {
+		t.Errorf("unsigned: err = %v", err)
+	}
+}
+
+func TestSignedClientIPRotation(t *testing.T) {
+	old, _ := NewSignedClientIP([]string{"fedcba9876543210"}, time.Minute)
+	s, _ := NewSignedClientIP([]string{"0123456789abcdef", "fedcba9876543210"}, time.Minute)
+
+	r := httptest.NewRequest("GET", "http://ipquery.example/own", nil)
+	ts, sig := old.Sign(netip.MustParseAddr("2001:db8::1"), time.Now(), r)
+	r.Header.Set(SignedClientIPHeader, "2001:db8::1")
+	r.Header.Set(SignedTimestampHeader, ts)
+	r.Header.Set(SignedSignatureHeader, sig)
+	if _, err := s.Verify(r); err != nil {
+		t.Errorf("signature under the second secret: %v", err)
+	}
+
+	if _, err := NewSignedClientIP([]string{"short"}, time.Minute); err == nil {
+		t.Error("short secret accepted")
+	}
+	if _, err := NewSignedClientIP([]string{"0123456789abcdef"}, 0); err == nil {
+		t.Error("zero max age accepted")
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sourceFetchTimeout = 30 * time.Second
+	sourceMaxBytes     = 64 << 20
+)
+
+var sourceHttpClient = &http.Client{Timeout: sourceFetchTimeout}
+
+func isRemoteSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// openSource opens a data source that is either a local file path or an
+// http(s) URL.
+func openSource(src string) (io.ReadCloser, error) {
+	if !isRemoteSource(src) {
+		return os.Open(src)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ipquery")
+
+	httpResponse, err := sourceHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		httpResponse.Body.Close()
+		return nil, fmt.Errorf("http status %d", httpResponse.StatusCode)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(httpResponse.Body, sourceMaxBytes), httpResponse.Body}, nil
+}
+
+// refreshLoop calls a function on a fixed interval until it is stopped.
+type refreshLoop struct {
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (l *refreshLoop) start(interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+
+	l.done = make(chan struct{})
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (l *refreshLoop) stop() {
+	if l.done == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.done = nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TorExitList struct {
+	source string
+
+	mu          sync.RWMutex
+	exits       map[netip.Addr]struct{}
+	refreshedAt time.Time
+
+	loop refreshLoop
+}
+
+func NewTorExitList(source string) *TorExitList {
+	return &TorExitList{
+		source: source,
+		exits:  map[netip.Addr]struct{}{},
+	}
+}
+
+// Start loads the list and reloads it every interval. A failed load keeps
+// the previous list in place.
+func (t *TorExitList) Start(interval time.Duration) {
+	if err := t.Refresh(); err != nil {
+		log.Printf("tor exit list %s: %v", t.source, err)
+	}
+	t.loop.start(interval, func() {
+		if err := t.Refresh(); err != nil {
+			log.Printf("tor exit list %s: %v", t.source, err)
+		}
+	})
+}
+
+func (t *TorExitList) Close() error {
+	t.loop.stop()
+	return nil
+}
+
+func (t *TorExitList) Refresh() error {
+	rc, err := openSource(t.source)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	exits := map[netip.Addr]struct{}{}
+	sc := bufio.NewScanner(rc)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Also accept the older exit-addresses format: "ExitAddress <ip> <date>"
+		if fields := strings.Fields(line); len(fields) > 1 {
+			if fields[0] != "ExitAddress" {
+				continue
+			}
+			line = fields[1]
+		}
+		addr, err := netip.ParseAddr(line)
+		if err != nil {
+			continue
+		}
+		exits[addr.Unmap()] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(exits) == 0 {
+		return fmt.Errorf("no exit addresses found")
+	}
+
+	t.mu.Lock()
+	t.exits = exits
+	t.refreshedAt = time.Now().UTC()
+	t.mu.Unlock()
+
+	log.Printf("tor exit list refreshed: %d addresses", len(exits))
+	return nil
+}
+
+func (t *TorExitList) Contains(addr netip.Addr) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, ok := t.exits[addr.Unmap()]
+	return ok
+}
+
+func (t *TorExitList) RefreshedAt() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.refreshedAt
+}
+
+func (t *TorExitList) Enrich(ip net.IP, out *LookupResult) error {
+	addr, ok := netIPToNetipAddr(ip)
+	if !ok {
+		return nil
+	}
+
+	refreshedAt := t.RefreshedAt()
+	if refreshedAt.IsZero() {
+		return nil
+	}
+
+	if t.Contains(addr) {
+		out.Risk.IsTor = ptr(true)
+	} else if out.Risk.IsTor == nil {
+		out.Risk.IsTor = ptr(false)
+	}
+	out.Risk.TorListRefreshedAt = &refreshedAt
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache is a small concurrency-safe cache whose entries expire after a
+// fixed TTL. Expired entries are dropped lazily on access and on Set.
+type ttlCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[K]ttlCacheEntry[V]
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration, maxSize int) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: map[K]ttlCacheEntry[V]{},
+	}
+}
+
+func (c *ttlCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[K, V]) Set(key K, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full: evict an arbitrary entry rather than growing unbounded.
+		for k := range c.entries {
+			if len(c.entries) < c.maxSize {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = ttlCacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
@@ -10,63 +10,250 @@ type LookupResult struct {
 	ISP      ISPInfo      `json:"isp"`
 	Location LocationInfo `json:"location"`
 	Risk     RiskInfo     `json:"risk"`
+	Hosting  *HostingInfo `json:"hosting,omitempty"`
+	// Forwarded is the proxy chain of the request, only reported for the
+	// caller's own address.
+	Forwarded []ForwardedElement `json:"forwarded,omitempty"`
+	// ProxyProtocol is the PROXY protocol header of the caller's connection.
+	ProxyProtocol *ProxyProtocolInfo `json:"proxy_protocol,omitempty"`
+}
+
+// DataStatus tells whether a part of a lookup result was actually
+// determined, so that missing data is not mistaken for a clean result.
+type DataStatus string
+
+const (
+	DataChecked     DataStatus = "checked"
+	DataCached      DataStatus = "cached"
+	DataUnavailable DataStatus = "unavailable"
+	DataDisabled    DataStatus = "disabled"
+	DataNotFound    DataStatus = "not_found"
+)
+
+type HostingInfo struct {
+	Provider string `json:"provider"`
+	Region   string `json:"region"`
+	Service  string `json:"service"`
+	Prefix   string `json:"prefix"`
 }
 
 type ISPInfo struct {
-	ASN string `json:"asn"`
-	Org string `json:"org"`
-	ISP string `json:"isp"`
+	Status        DataStatus `json:"status"`
+	ASN           string     `json:"asn"`
+	Org           string     `json:"org"`
+	ISP           string     `json:"isp"`
+	Type          string     `json:"type,omitempty"`
+	IsVpn         bool       `json:"is_vpn"`
+	IsHosting     bool       `json:"is_hosting"`
+	IsResidential bool       `json:"is_residential"`
+	IsMobile      bool       `json:"is_mobile"`
+	IsEducation   bool       `json:"is_education"`
+	IsGovernment  bool       `json:"is_government"`
 }
 
 type LocationInfo struct {
-	Country     string  `json:"country"`
-	CountryCode string  `json:"country_code"`
-	City        string  `json:"city"`
-	State       string  `json:"state"`
-	Zipcode     string  `json:"zipcode"`
-	Latitude    float64 `json:"latitude"`
-	Longitude   float64 `json:"longitude"`
-	Timezone    string  `json:"timezone"`
-	Localtime   string  `json:"localtime"`
+	Status      DataStatus `json:"status"`
+	Country     string     `json:"country"`
+	CountryCode string     `json:"country_code"`
+	City        string     `json:"city"`
+	State       string     `json:"state"`
+	Zipcode     string     `json:"zipcode"`
+	Latitude    *float64   `json:"latitude"`
+	Longitude   *float64   `json:"longitude"`
+	Timezone    string     `json:"timezone"`
+	Localtime   string     `json:"localtime"`
 }
 
+// RiskInfo combines ipquery's risk score with the signals it is computed
+// from. Status and CheckedAt refer to the AbuseIPDB check; its fields are
+// null unless the check succeeded. IsTor is null unless AbuseIPDB or the
+// Tor exit list answered. Score is null unless a RiskScorer ran and either
+// found a signal or had at least one risk source answer for the address.
+// Dnsbl may miss listings if DnsblStatus is unavailable.
 type RiskInfo struct {
-	AbuseConfidenceScore  int       `json:"abuse_confidence_score"`
-	UsageType             string    `json:"usage_type"`
-	IsTor                 bool      `json:"is_tor"`
-	TotalReports          int       `json:"total_reports"`
-	NumberOfUsersReported int       `json:"number_of_users_reported"`
-	LastReportedAt        time.Time `json:"last_reported_at"`
+	Score                 *int                 `json:"score"`
+	Reasons               []RiskReason         `json:"reasons"`
+	Status                DataStatus           `json:"status"`
+	CheckedAt             *time.Time           `json:"checked_at"`
+	AbuseConfidenceScore  *int                 `json:"abuse_confidence_score"`
+	IsAbusive             *bool                `json:"is_abusive"`
+	UsageType             *string              `json:"usage_type"`
+	IsTor                 *bool                `json:"is_tor"`
+	TotalReports          *int                 `json:"total_reports"`
+	NumberOfUsersReported *int                 `json:"number_of_users_reported"`
+	LastReportedAt        *time.Time           `json:"last_reported_at"`
+	TorListRefreshedAt    *time.Time           `json:"tor_list_refreshed_at,omitempty"`
+	Lists                 []string             `json:"lists"`
+	Dnsbl                 []DnsblListing       `json:"dnsbl,omitempty"`
+	DnsblStatus           DataStatus           `json:"dnsbl_status,omitempty"`
+	Reports               *RiskReports         `json:"reports,omitempty"`
+	Local                 *LocalSighting       `json:"local,omitempty"`
+	IsAllowlisted         bool                 `json:"is_allowlisted"`
+	IsDenylisted          bool                 `json:"is_denylisted"`
+	ManagedLists          []ManagedListMatch   `json:"managed_lists,omitempty"`
+	Reputation            []ProviderReputation `json:"reputation,omitempty"`
+}
+
+// ProviderReputation is the verdict of a third-party reputation API,
+// normalized to a 0-100 score. Score and Malicious are null if the
+// provider could not be asked.
+type ProviderReputation struct {
+	Provider       string         `json:"provider"`
+	Status         DataStatus     `json:"status"`
+	CheckedAt      *time.Time     `json:"checked_at"`
+	Score          *int           `json:"score"`
+	Malicious      *bool          `json:"malicious"`
+	Classification string         `json:"classification,omitempty"`
+	Tags           []string       `json:"tags"`
+	Fields         map[string]any `json:"fields,omitempty"`
+}
+
+type ManagedListMatch struct {
+	List    string          `json:"list"`
+	Kind    ManagedListKind `json:"kind"`
+	Entry   string          `json:"entry"`
+	Comment string          `json:"comment,omitempty"`
+}
+
+type ManagedListSummary struct {
+	Name    string          `json:"name"`
+	Kind    ManagedListKind `json:"kind"`
+	Entries int             `json:"entries"`
+}
+
+type LocalSighting struct {
+	FirstSeen  time.Time        `json:"first_seen"`
+	LastSeen   time.Time        `json:"last_seen"`
+	Requests   int64            `json:"requests"`
+	UserAgents map[string]int64 `json:"user_agents"`
+	Endpoints  map[string]int64 `json:"endpoints"`
+}
+
+type RiskReason struct {
+	Factor string  `json:"factor"`
+	Weight float64 `json:"weight"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail"`
+}
+
+type RiskReports struct {
+	IsWhitelisted     bool                   `json:"is_whitelisted"`
+	IsPublic          bool                   `json:"is_public"`
+	Domain            string                 `json:"domain"`
+	Hostnames         []string               `json:"hostnames"`
+	Categories        []ReportCategoryCount  `json:"categories"`
+	ReporterCountries []ReporterCountryCount `json:"reporter_countries"`
+	LatestComments    []ReportComment        `json:"latest_comments"`
+}
+
+type DnsblListing struct {
+	Zone    string   `json:"zone"`
+	List    string   `json:"list"`
+	Codes   []string `json:"codes"`
+	Reasons []string `json:"reasons"`
+}
+
+type ReportCategoryCount struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type ReporterCountryCount struct {
+	CountryCode string `json:"country_code"`
+	Country     string `json:"country"`
+	Count       int    `json:"count"`
+}
+
+type ReportComment struct {
+	ReportedAt time.Time `json:"reported_at"`
+	Comment    string    `json:"comment"`
+	Categories []string  `json:"categories"`
 }
 
 type AbuseIpDbCheckResult struct {
 	Data struct {
-		IpAddress            string        `json:"ipAddress"`
-		IsPublic             bool          `json:"isPublic"`
-		IpVersion            int           `json:"ipVersion"`
-		IsWhitelisted        bool          `json:"isWhitelisted"`
-		AbuseConfidenceScore int           `json:"abuseConfidenceScore"`
-		CountryCode          string        `json:"countryCode"`
-		CountryName          string        `json:"countryName"`
-		UsageType            string        `json:"usageType"`
-		Isp                  string        `json:"isp"`
-		Domain               string        `json:"domain"`
-		Hostnames            []interface{} `json:"hostnames"`
-		IsTor                bool          `json:"isTor"`
-		TotalReports         int           `json:"totalReports"`
-		NumDistinctUsers     int           `json:"numDistinctUsers"`
-		LastReportedAt       time.Time     `json:"lastReportedAt"`
-		Reports              []struct {
-			ReportedAt          time.Time `json:"reportedAt"`
-			Comment             string    `json:"comment"`
-			Categories          []int     `json:"categories"`
-			ReporterId          int       `json:"reporterId"`
-			ReporterCountryCode string    `json:"reporterCountryCode"`
-			ReporterCountryName string    `json:"reporterCountryName"`
-		} `json:"reports"`
+		IpAddress            string            `json:"ipAddress"`
+		IsPublic             bool              `json:"isPublic"`
+		IpVersion            int               `json:"ipVersion"`
+		IsWhitelisted        bool              `json:"isWhitelisted"`
+		AbuseConfidenceScore int               `json:"abuseConfidenceScore"`
+		CountryCode          string            `json:"countryCode"`
+		CountryName          string            `json:"countryName"`
+		UsageType            string            `json:"usageType"`
+		Isp                  string            `json:"isp"`
+		Domain               string            `json:"domain"`
+		Hostnames            []string          `json:"hostnames"`
+		IsTor                bool              `json:"isTor"`
+		TotalReports         int               `json:"totalReports"`
+		NumDistinctUsers     int               `json:"numDistinctUsers"`
+		LastReportedAt       time.Time         `json:"lastReportedAt"`
+		Reports              []AbuseIpDbReport `json:"reports"`
 	} `json:"data"`
 }
 
+type AbuseIpDbReport struct {
+	ReportedAt          time.Time `json:"reportedAt"`
+	Comment             string    `json:"comment"`
+	Categories          []int     `json:"categories"`
+	ReporterId          int       `json:"reporterId"`
+	ReporterCountryCode string    `json:"reporterCountryCode"`
+	ReporterCountryName string    `json:"reporterCountryName"`
+}
+
+type AbuseIpDbCheckBlockResult struct {
+	Data struct {
+		NetworkAddress   string `json:"networkAddress"`
+		Netmask          string `json:"netmask"`
+		MinAddress       string `json:"minAddress"`
+		MaxAddress       string `json:"maxAddress"`
+		NumPossibleHosts int    `json:"numPossibleHosts"`
+		AddressSpaceDesc string `json:"addressSpaceDesc"`
+		ReportedAddress  []struct {
+			IpAddress            string    `json:"ipAddress"`
+			NumReports           int       `json:"numReports"`
+			MostRecentReport     time.Time `json:"mostRecentReport"`
+			AbuseConfidenceScore int       `json:"abuseConfidenceScore"`
+			CountryCode          string    `json:"countryCode"`
+			Categories           []int     `json:"categories"`
+		} `json:"reportedAddress"`
+	} `json:"data"`
+}
+
+type BlockRiskResult struct {
+	Network           string                 `json:"network"`
+	NetworkAddress    string                 `json:"network_address"`
+	Netmask           string                 `json:"netmask"`
+	MinAddress        string                 `json:"min_address"`
+	MaxAddress        string                 `json:"max_address"`
+	NumPossibleHosts  int                    `json:"num_possible_hosts"`
+	AddressSpaceDesc  string                 `json:"address_space_desc"`
+	ReportedAddresses []BlockReportedAddress `json:"reported_addresses"`
+	CheckedAt         time.Time              `json:"checked_at"`
+	Cached            bool                   `json:"cached"`
+}
+
+type BlockReportedAddress struct {
+	IP                   string    `json:"ip"`
+	NumReports           int       `json:"num_reports"`
+	AbuseConfidenceScore int       `json:"abuse_confidence_score"`
+	CountryCode          string    `json:"country_code"`
+	Categories           []string  `json:"categories,omitempty"`
+	LastReportedAt       time.Time `json:"last_reported_at"`
+}
+
+type PolicyResult struct {
+	IP       string             `json:"ip"`
+	Decision PolicyDecision     `json:"decision"`
+	Rule     *PolicyMatchedRule `json:"rule"`
+	Facts    PolicyFacts        `json:"facts"`
+}
+
+type PolicyMatchedRule struct {
+	Name string `json:"name"`
+	When string `json:"when"`
+}
+
 type Enricher interface {
 	Enrich(ip net.IP, out *LookupResult) error
 }
@@ -24,3 +24,16 @@ func netIPToNetipAddr(ip net.IP) (netip.Addr, bool) {
 	copy(b[:], ip16)
 	return netip.AddrFrom16(b), true
 }
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// deref returns the value v points to, or the zero value if v is nil.
+func deref[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	ipqapi "github.com/akyriako/ipquery/api"
 	"github.com/caarlos0/env/v11"
@@ -14,61 +21,592 @@ import (
 )
 
 type Config struct {
-	TrustedProxyCIDRs []string `env:"TRUSTED_PROXY_CIDRS" envSeparator:"," envDefault:"127.0.0.1/32,::1/128"`
-	ListenAddr        string   `env:"LISTEN_ADDR" envDefault:":8080"`
-	GeoLiteAsn        string   `env:"GEOLITE2_ASN" envDefault:"./geolite/GeoLite2-ASN.mmdb"`
-	GeoLiteCity       string   `env:"GEOLITE2_CITY" envDefault:"./geolite/GeoLite2-City.mmdb"`
-	AbuseIpDbApiKey   *string  `env:"ABUSEIPDB_API_KEY"`
+	TrustedProxyCIDRs     []string      `env:"TRUSTED_PROXY_CIDRS" envSeparator:"," envDefault:"127.0.0.1/32,::1/128"`
+	TrustedProxyHeaders   []string      `env:"TRUSTED_PROXY_HEADERS" envSeparator:","`
+	TrustedProxyHops      int           `env:"TRUSTED_PROXY_HOPS" envDefault:"0"`
+	ProxyPresets          []string      `env:"TRUSTED_PROXY_PRESETS" envSeparator:","`
+	ProxyPresetDir        string        `env:"TRUSTED_PROXY_PRESET_DIR" envDefault:"./data/proxy-presets"`
+	ProxyPresetSources    []string      `env:"TRUSTED_PROXY_PRESET_SOURCES" envSeparator:","`
+	ProxyPresetRefresh    time.Duration `env:"TRUSTED_PROXY_PRESET_REFRESH" envDefault:"0s"`
+	SignedIPSecrets       []string      `env:"SIGNED_CLIENT_IP_SECRETS" envSeparator:","`
+	SignedIPMaxAge        time.Duration `env:"SIGNED_CLIENT_IP_MAX_AGE" envDefault:"30s"`
+	ListenAddr            string        `env:"LISTEN_ADDR" envDefault:":8080"`
+	ShutdownTimeout       time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	ProxyProtocolAddr     string        `env:"PROXY_PROTOCOL_LISTEN_ADDR"`
+	ProxyProtocolCIDRs    []string      `env:"PROXY_PROTOCOL_CIDRS" envSeparator:","`
+	ProxyProtocolTimeout  time.Duration `env:"PROXY_PROTOCOL_HEADER_TIMEOUT" envDefault:"5s"`
+	ProxyProtocolOptional bool          `env:"PROXY_PROTOCOL_OPTIONAL" envDefault:"false"`
+	OwnV4Url              string        `env:"OWN_IPV4_URL"`
+	OwnV6Url              string        `env:"OWN_IPV6_URL"`
+	OwnV4ListenAddr       string        `env:"OWN_IPV4_LISTEN_ADDR"`
+	OwnV6ListenAddr       string        `env:"OWN_IPV6_LISTEN_ADDR"`
+	GeoLiteAsn            string        `env:"GEOLITE2_ASN" envDefault:"./geolite/GeoLite2-ASN.mmdb"`
+	GeoLiteCity           string        `env:"GEOLITE2_CITY" envDefault:"./geolite/GeoLite2-City.mmdb"`
+	AbuseIpDbApiKey       *string       `env:"ABUSEIPDB_API_KEY"`
+	AbuseIpDbReports      bool          `env:"ABUSEIPDB_REPORTS" envDefault:"false"`
+	AbuseIpDbConfig
+	ReportConfig
+	TorExitList        string        `env:"TOR_EXIT_LIST"`
+	TorExitListRefresh time.Duration `env:"TOR_EXIT_LIST_REFRESH" envDefault:"1h"`
+	BlocklistFeeds     []string      `env:"BLOCKLIST_FEEDS" envSeparator:","`
+	BlocklistRefresh   time.Duration `env:"BLOCKLIST_REFRESH" envDefault:"6h"`
+	DnsblZones         []string      `env:"DNSBL_ZONES" envSeparator:","`
+	DnsblResolver      string        `env:"DNSBL_RESOLVER"`
+	DnsblTimeout       time.Duration `env:"DNSBL_TIMEOUT" envDefault:"1s"`
+	DnsblCacheTTL      time.Duration `env:"DNSBL_CACHE_TTL" envDefault:"1h"`
+	HostingRanges      []string      `env:"HOSTING_RANGES" envSeparator:","`
+	HostingRefresh     time.Duration `env:"HOSTING_RANGES_REFRESH" envDefault:"0s"`
+	PolicyFile         string        `env:"POLICY_FILE"`
+	PolicyReload       time.Duration `env:"POLICY_RELOAD_INTERVAL" envDefault:"10s"`
+	ForwardAuth        bool          `env:"FORWARD_AUTH" envDefault:"false"`
+	AsnTypesFiles      []string      `env:"ASN_TYPES_FILES" envSeparator:"," envDefault:"./data/asn-types.txt"`
+	AsnTypesRefresh    time.Duration `env:"ASN_TYPES_REFRESH" envDefault:"0s"`
+	SightingsFile      string        `env:"SIGHTINGS_FILE"`
+	SightingsRetention time.Duration `env:"SIGHTINGS_RETENTION" envDefault:"720h"`
+	SightingsMax       int           `env:"SIGHTINGS_MAX_ENTRIES" envDefault:"100000"`
+	SightingsFlush     time.Duration `env:"SIGHTINGS_FLUSH_INTERVAL" envDefault:"1m"`
+	SightingsApiKeys   []string      `env:"SIGHTINGS_API_KEYS" envSeparator:","`
+	ForwardAuthPolicy  string        `env:"FORWARD_AUTH_POLICY_FILE"`
+	ForwardAuthCache   time.Duration `env:"FORWARD_AUTH_CACHE_TTL" envDefault:"30s"`
+	ForwardAuthStatus  int           `env:"FORWARD_AUTH_CHALLENGE_STATUS" envDefault:"401"`
+	ListsFile          string        `env:"LISTS_FILE"`
+	ListsApiKeys       []string      `env:"LISTS_API_KEYS" envSeparator:","`
+	RiskWeightConfig
+	Ipqs       ReputationConfig `envPrefix:"IPQS_"`
+	GreyNoise  ReputationConfig `envPrefix:"GREYNOISE_"`
+	VirusTotal ReputationConfig `envPrefix:"VIRUSTOTAL_"`
+	Shodan     ReputationConfig `envPrefix:"SHODAN_INTERNETDB_"`
+}
+
+// ReputationConfig configures one third-party reputation provider. A
+// provider is enabled by its API key, or by ENABLED for those that work
+// without one.
+type ReputationConfig struct {
+	Enabled    bool          `env:"ENABLED" envDefault:"false"`
+	ApiKey     string        `env:"API_KEY"`
+	BaseUrl    string        `env:"BASE_URL"`
+	Timeout    time.Duration `env:"TIMEOUT" envDefault:"2s"`
+	CacheTTL   time.Duration `env:"CACHE_TTL" envDefault:"6h"`
+	DailyQuota int           `env:"DAILY_QUOTA" envDefault:"0"`
+	Fields     []string      `env:"FIELDS" envSeparator:","`
+}
+
+type RiskWeightConfig struct {
+	Abuse         float64       `env:"RISK_WEIGHT_ABUSE" envDefault:"50"`
+	Tor           float64       `env:"RISK_WEIGHT_TOR" envDefault:"30"`
+	Hosting       float64       `env:"RISK_WEIGHT_HOSTING" envDefault:"15"`
+	BlocklistHit  float64       `env:"RISK_WEIGHT_BLOCKLIST_HIT" envDefault:"20"`
+	BlocklistMax  float64       `env:"RISK_WEIGHT_BLOCKLIST_MAX" envDefault:"40"`
+	Anonymous     float64       `env:"RISK_WEIGHT_ANONYMOUS" envDefault:"25"`
+	Recency       float64       `env:"RISK_WEIGHT_RECENCY" envDefault:"15"`
+	RecencyWindow time.Duration `env:"RISK_RECENCY_WINDOW" envDefault:"720h"`
+	Reputation    float64       `env:"RISK_WEIGHT_REPUTATION" envDefault:"30"`
+}
+
+type ReportConfig struct {
+	ApiKeys    []string      `env:"REPORT_API_KEYS" envSeparator:","`
+	DailyQuota int           `env:"ABUSEIPDB_REPORT_DAILY_QUOTA" envDefault:"1000"`
+	QueueSize  int           `env:"ABUSEIPDB_REPORT_QUEUE_SIZE" envDefault:"256"`
+	Timeout    time.Duration `env:"ABUSEIPDB_REPORT_TIMEOUT" envDefault:"10s"`
+}
+
+type AbuseIpDbConfig struct {
+	BaseUrl       string        `env:"ABUSEIPDB_BASE_URL" envDefault:"https://api.abuseipdb.com/api/v2"`
+	Timeout       time.Duration `env:"ABUSEIPDB_TIMEOUT" envDefault:"500ms"`
+	MaxAgeInDays  int           `env:"ABUSEIPDB_MAX_AGE_DAYS" envDefault:"90"`
+	Verbose       bool          `env:"ABUSEIPDB_VERBOSE" envDefault:"true"`
+	MinConfidence int           `env:"ABUSEIPDB_MIN_CONFIDENCE" envDefault:"75"`
+	BlockMinV4    int           `env:"ABUSEIPDB_BLOCK_MIN_PREFIX_V4" envDefault:"24"`
+	BlockMinV6    int           `env:"ABUSEIPDB_BLOCK_MIN_PREFIX_V6" envDefault:"112"`
+	BlockCacheTTL time.Duration `env:"ABUSEIPDB_BLOCK_CACHE_TTL" envDefault:"1h"`
+	BlockApiKeys  []string      `env:"ABUSEIPDB_BLOCK_API_KEYS" envSeparator:","`
+	CacheTTL      time.Duration `env:"ABUSEIPDB_CACHE_TTL" envDefault:"1h"`
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, then shuts the server down gracefully
+// and returns, so that deferred Close calls flush state to disk. It returns
+// errors rather than exiting for the same reason.
+func run() error {
 	log.Print("starting ipquery server")
 
 	var cfg Config
 	if err := env.Parse(&cfg); err != nil {
-		log.Fatalf("parse env: %v", err)
+		return fmt.Errorf("parse env: %v", err)
 	}
 
 	trusted, err := parseCIDRs(cfg.TrustedProxyCIDRs)
 	if err != nil {
-		log.Fatalf("invalid TRUSTED_PROXY_CIDRS: %v", err)
+		return fmt.Errorf("invalid TRUSTED_PROXY_CIDRS: %v", err)
 	}
 
 	log.Printf("trustedProxies: %v", trusted)
 
+	proxyHeaders, err := parseProxyHeaders(cfg.TrustedProxyHeaders)
+	if err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXY_HEADERS: %v", err)
+	}
+	if len(proxyHeaders) > 0 {
+		log.Printf("proxyHeaders: %v", proxyHeaders)
+	}
+
+	var presets *ipqapi.ProxyPresets
+	if len(cfg.ProxyPresets) > 0 {
+		sources, err := parsePresetSources(cfg.ProxyPresetSources)
+		if err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXY_PRESET_SOURCES: %v", err)
+		}
+		presets, err = ipqapi.NewProxyPresets(cfg.ProxyPresets, cfg.ProxyPresetDir, sources)
+		if err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXY_PRESETS: %v", err)
+		}
+		presets.Start(cfg.ProxyPresetRefresh)
+		defer presets.Close()
+
+		log.Printf("trustedProxyPresets: %v", cfg.ProxyPresets)
+	}
+
+	var signedIPs *ipqapi.SignedClientIP
+	if len(cfg.SignedIPSecrets) > 0 {
+		if cfg.SignedIPMaxAge <= 0 {
+			return fmt.Errorf("invalid SIGNED_CLIENT_IP_MAX_AGE: %s must be positive", cfg.SignedIPMaxAge)
+		}
+		signedIPs, err = ipqapi.NewSignedClientIP(cfg.SignedIPSecrets, cfg.SignedIPMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid SIGNED_CLIENT_IP_SECRETS: %v", err)
+		}
+
+		log.Printf("signed client ip: secrets=%d maxAge=%s", len(cfg.SignedIPSecrets), cfg.SignedIPMaxAge)
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		return fmt.Errorf("invalid SHUTDOWN_TIMEOUT: must be positive")
+	}
+
+	if cfg.TrustedProxyHops < 0 {
+		return fmt.Errorf("invalid TRUSTED_PROXY_HOPS: %d must not be negative", cfg.TrustedProxyHops)
+	}
+
+	weights := ipqapi.RiskWeights(cfg.RiskWeightConfig)
+	if err := validateRiskWeights(weights); err != nil {
+		return fmt.Errorf("invalid risk weights: %v", err)
+	}
+
+	hosting, err := parseHostingSpecs(cfg.HostingRanges)
+	if err != nil {
+		return fmt.Errorf("invalid HOSTING_RANGES: %v", err)
+	}
+
+	if cfg.DnsblResolver != "" {
+		if _, _, err := net.SplitHostPort(cfg.DnsblResolver); err != nil {
+			return fmt.Errorf("invalid DNSBL_RESOLVER: %v", err)
+		}
+	}
+	if cfg.DnsblTimeout <= 0 {
+		return fmt.Errorf("invalid DNSBL_TIMEOUT: %s must be positive", cfg.DnsblTimeout)
+	}
+	if cfg.DnsblCacheTTL < 0 {
+		return fmt.Errorf("invalid DNSBL_CACHE_TTL: %s must not be negative", cfg.DnsblCacheTTL)
+	}
+
+	feeds, err := parseFeedSpecs(cfg.BlocklistFeeds)
+	if err != nil {
+		return fmt.Errorf("invalid BLOCKLIST_FEEDS: %v", err)
+	}
+
+	if err := validateAbuseIpDbConfig(cfg.AbuseIpDbConfig, cfg.AbuseIpDbReports); err != nil {
+		return fmt.Errorf("invalid abuseipdb config: %v", err)
+	}
+
+	if cfg.ReportConfig.DailyQuota < 1 || cfg.ReportConfig.QueueSize < 1 || cfg.ReportConfig.Timeout <= 0 {
+		return fmt.Errorf("invalid report config: ABUSEIPDB_REPORT_DAILY_QUOTA, ABUSEIPDB_REPORT_QUEUE_SIZE and ABUSEIPDB_REPORT_TIMEOUT must be positive")
+	}
+
+	reputation := []struct {
+		name, prefix string
+		needsKey     bool
+		cfg          ReputationConfig
+	}{
+		{"ipqualityscore", "IPQS_", true, cfg.Ipqs},
+		{"greynoise", "GREYNOISE_", false, cfg.GreyNoise},
+		{"virustotal", "VIRUSTOTAL_", true, cfg.VirusTotal},
+		{"shodan", "SHODAN_INTERNETDB_", false, cfg.Shodan},
+	}
+	for _, p := range reputation {
+		if err := validateReputationConfig(p.prefix, p.needsKey, p.cfg); err != nil {
+			return fmt.Errorf("invalid %s config: %v", p.name, err)
+		}
+	}
+
 	asn, err := ipqapi.NewAsnReader(cfg.GeoLiteAsn)
 	if err != nil {
-		log.Fatalf("asn reader error: %v", err)
+		return fmt.Errorf("asn reader error: %v", err)
 	}
 	defer asn.Close()
 
 	city, err := ipqapi.NewCityReader(cfg.GeoLiteCity)
 	if err != nil {
-		log.Fatalf("city reader error: %v", err)
+		return fmt.Errorf("city reader error: %v", err)
 	}
 	defer city.Close()
 
-	lc := &ipqapi.LookupClient{TrustedProxies: trusted, AsnReader: asn, CityReader: city}
+	lc := &ipqapi.LookupClient{
+		TrustedProxies: trusted,
+		ProxyHeaders:   proxyHeaders,
+		ProxyPresets:   presets,
+		SignedIPs:      signedIPs,
+		TrustedHops:    cfg.TrustedProxyHops,
+		AsnReader:      asn,
+		CityReader:     city,
+		RiskScorer:     ipqapi.NewRiskScorer(weights),
+	}
+	apis := ipqapi.Server{LookupClient: lc}
+
+	for name, u := range map[string]string{"OWN_IPV4_URL": cfg.OwnV4Url, "OWN_IPV6_URL": cfg.OwnV6Url} {
+		if err := validateOwnUrl(u); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	apis.OwnV4Url = strings.TrimSuffix(cfg.OwnV4Url, "/")
+	apis.OwnV6Url = strings.TrimSuffix(cfg.OwnV6Url, "/")
+
+	if cfg.SightingsFile != "" {
+		if cfg.SightingsRetention <= 0 || cfg.SightingsMax < 1 || cfg.SightingsFlush <= 0 {
+			return fmt.Errorf("invalid sightings config: SIGHTINGS_RETENTION, SIGHTINGS_MAX_ENTRIES and SIGHTINGS_FLUSH_INTERVAL must be positive")
+		}
+		// Sightings reveal who uses this service, so they are admin-only,
+		// like the managed lists.
+		apis.SightingsApiKeys = cfg.SightingsApiKeys
+		if len(apis.SightingsApiKeys) == 0 {
+			apis.SightingsApiKeys = cfg.ListsApiKeys
+		}
+		if len(apis.SightingsApiKeys) == 0 {
+			return fmt.Errorf("invalid sightings config: SIGHTINGS_API_KEYS or LISTS_API_KEYS is required with SIGHTINGS_FILE")
+		}
+
+		sightings, err := ipqapi.NewSightingsStore(ipqapi.SightingsOptions{
+			Path:       cfg.SightingsFile,
+			Retention:  cfg.SightingsRetention,
+			MaxEntries: cfg.SightingsMax,
+		})
+		if err != nil {
+			return fmt.Errorf("sightings store error: %v", err)
+		}
+		sightings.Start(cfg.SightingsFlush)
+		defer sightings.Close()
+		apis.Sightings = sightings
+
+		log.Printf("sightings: file=%s retention=%s", cfg.SightingsFile, cfg.SightingsRetention)
+	}
+
+	for _, p := range reputation {
+		if !p.cfg.Enabled && p.cfg.ApiKey == "" {
+			continue
+		}
+
+		provider, err := ipqapi.NewReputationProvider(p.name, ipqapi.ReputationOptions{
+			BaseUrl:    p.cfg.BaseUrl,
+			ApiKey:     p.cfg.ApiKey,
+			Timeout:    p.cfg.Timeout,
+			CacheTTL:   p.cfg.CacheTTL,
+			DailyQuota: p.cfg.DailyQuota,
+			Fields:     p.cfg.Fields,
+		})
+		if err != nil {
+			return fmt.Errorf("reputation provider error: %v", err)
+		}
+		lc.Enrichers = append(lc.Enrichers, provider)
+
+		log.Printf("reputation provider %s: timeout=%s cacheTTL=%s dailyQuota=%d", p.name, p.cfg.Timeout, p.cfg.CacheTTL, p.cfg.DailyQuota)
+	}
+
+	if cfg.ListsFile != "" {
+		if len(cfg.ListsApiKeys) == 0 {
+			return fmt.Errorf("invalid lists config: LISTS_API_KEYS is required with LISTS_FILE")
+		}
+
+		lists, err := ipqapi.NewManagedLists(cfg.ListsFile)
+		if err != nil {
+			return fmt.Errorf("managed lists error: %v", err)
+		}
+		lc.Enrichers = append(lc.Enrichers, lists)
+		apis.Lists = lists
+	}
+
+	if len(cfg.AsnTypesFiles) > 0 {
+		classifier := ipqapi.NewAsnClassifier(cfg.AsnTypesFiles)
+		classifier.Start(cfg.AsnTypesRefresh)
+		defer classifier.Close()
+		lc.Enrichers = append(lc.Enrichers, classifier)
+
+		log.Printf("asn classifier: sources=%v refresh=%s", cfg.AsnTypesFiles, cfg.AsnTypesRefresh)
+	}
+
+	if cfg.TorExitList != "" {
+		tor := ipqapi.NewTorExitList(cfg.TorExitList)
+		tor.Start(cfg.TorExitListRefresh)
+		defer tor.Close()
+		lc.Enrichers = append(lc.Enrichers, tor)
+
+		log.Printf("tor exit list: source=%s refresh=%s", cfg.TorExitList, cfg.TorExitListRefresh)
+	}
+
+	if len(feeds) > 0 {
+		blocklists := ipqapi.NewBlocklistFeeds(feeds)
+		blocklists.Start(cfg.BlocklistRefresh)
+		defer blocklists.Close()
+		lc.Enrichers = append(lc.Enrichers, blocklists)
+
+		log.Printf("blocklist feeds: %d feeds refresh=%s", len(feeds), cfg.BlocklistRefresh)
+	}
+
+	if cfg.PolicyFile != "" {
+		policy, err := ipqapi.NewPolicyEngine(cfg.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("invalid POLICY_FILE: %v", err)
+		}
+		policy.Start(cfg.PolicyReload)
+		defer policy.Close()
+		apis.Policy = policy
+	}
+
+	switch {
+	case cfg.ForwardAuthPolicy != "" && cfg.ForwardAuthPolicy != cfg.PolicyFile:
+		policy, err := ipqapi.NewPolicyEngine(cfg.ForwardAuthPolicy)
+		if err != nil {
+			return fmt.Errorf("invalid FORWARD_AUTH_POLICY_FILE: %v", err)
+		}
+		policy.Start(cfg.PolicyReload)
+		defer policy.Close()
+		apis.AuthPolicy = policy
+	default:
+		apis.AuthPolicy = apis.Policy
+	}
+
+	if cfg.ForwardAuth {
+		// Without a policy, forward-auth would let every request through.
+		if apis.AuthPolicy == nil {
+			return fmt.Errorf("invalid forward auth config: FORWARD_AUTH_POLICY_FILE or POLICY_FILE is required with FORWARD_AUTH")
+		}
+		// nginx auth_request turns anything but 401 and 403 into a 500, so
+		// 429 only suits Caddy and Traefik.
+		if cfg.ForwardAuthStatus != http.StatusUnauthorized && cfg.ForwardAuthStatus != http.StatusTooManyRequests {
+			return fmt.Errorf("invalid FORWARD_AUTH_CHALLENGE_STATUS: %d must be 401 or 429", cfg.ForwardAuthStatus)
+		}
+		if cfg.ForwardAuthCache < 0 {
+			return fmt.Errorf("invalid FORWARD_AUTH_CACHE_TTL: %s must not be negative", cfg.ForwardAuthCache)
+		}
+		apis.ForwardAuthChallengeStatus = cfg.ForwardAuthStatus
+		if cfg.ForwardAuthCache > 0 {
+			apis.AuthCache = ipqapi.NewForwardAuthCache(cfg.ForwardAuthCache)
+		}
+	}
+
+	if len(hosting) > 0 {
+		ranges := ipqapi.NewHostingRanges(hosting)
+		ranges.Start(cfg.HostingRefresh)
+		defer ranges.Close()
+		lc.Enrichers = append(lc.Enrichers, ranges)
+
+		log.Printf("hosting ranges: %d documents refresh=%s", len(hosting), cfg.HostingRefresh)
+	}
+
+	if len(cfg.DnsblZones) > 0 {
+		lc.Enrichers = append(lc.Enrichers, ipqapi.NewDnsbl(ipqapi.DnsblOptions{
+			Zones:    cfg.DnsblZones,
+			Resolver: cfg.DnsblResolver,
+			Timeout:  cfg.DnsblTimeout,
+			CacheTTL: cfg.DnsblCacheTTL,
+		}))
+
+		log.Printf("dnsbl: zones=%v resolver=%q timeout=%s cache_ttl=%s", cfg.DnsblZones, cfg.DnsblResolver, cfg.DnsblTimeout, cfg.DnsblCacheTTL)
+	}
+
 	if cfg.AbuseIpDbApiKey != nil {
-		risk := ipqapi.NewAbuseIpDbChecker(*cfg.AbuseIpDbApiKey)
+		risk := ipqapi.NewAbuseIpDbChecker(*cfg.AbuseIpDbApiKey, ipqapi.AbuseIpDbOptions{
+			BaseUrl:        cfg.AbuseIpDbConfig.BaseUrl,
+			Timeout:        cfg.AbuseIpDbConfig.Timeout,
+			MaxAgeInDays:   cfg.AbuseIpDbConfig.MaxAgeInDays,
+			Verbose:        cfg.AbuseIpDbConfig.Verbose,
+			MinConfidence:  cfg.AbuseIpDbConfig.MinConfidence,
+			IncludeReports: cfg.AbuseIpDbReports,
+			CacheTTL:       cfg.AbuseIpDbConfig.CacheTTL,
+		})
 		lc.RiskChecker = risk
+
+		// Every uncached block check spends AbuseIPDB quota, so it is
+		// only offered to callers with a key.
+		if len(cfg.AbuseIpDbConfig.BlockApiKeys) > 0 {
+			apis.BlockChecker = ipqapi.NewAbuseIpDbBlockChecker(*cfg.AbuseIpDbApiKey, ipqapi.AbuseIpDbBlockOptions{
+				BaseUrl:      cfg.AbuseIpDbConfig.BaseUrl,
+				Timeout:      cfg.AbuseIpDbConfig.Timeout,
+				MaxAgeInDays: cfg.AbuseIpDbConfig.MaxAgeInDays,
+				MinPrefixV4:  cfg.AbuseIpDbConfig.BlockMinV4,
+				MinPrefixV6:  cfg.AbuseIpDbConfig.BlockMinV6,
+				CacheTTL:     cfg.AbuseIpDbConfig.BlockCacheTTL,
+			})
+		}
+
+		log.Printf("abuseipdb: baseUrl=%s timeout=%s maxAgeInDays=%d verbose=%t minConfidence=%d",
+			cfg.AbuseIpDbConfig.BaseUrl, cfg.AbuseIpDbConfig.Timeout, cfg.AbuseIpDbConfig.MaxAgeInDays,
+			cfg.AbuseIpDbConfig.Verbose, cfg.AbuseIpDbConfig.MinConfidence)
+	}
+
+	if cfg.AbuseIpDbApiKey != nil && len(cfg.ReportConfig.ApiKeys) > 0 {
+		reporter := ipqapi.NewAbuseIpDbReporter(*cfg.AbuseIpDbApiKey, ipqapi.AbuseIpDbReporterOptions{
+			BaseUrl:      cfg.AbuseIpDbConfig.BaseUrl,
+			Timeout:      cfg.ReportConfig.Timeout,
+			DailyQuota:   cfg.ReportConfig.DailyQuota,
+			QueueSize:    cfg.ReportConfig.QueueSize,
+			DrainTimeout: cfg.ShutdownTimeout,
+		})
+		reporter.Start()
+		defer reporter.Close()
+		apis.Reporter = reporter
+
+		log.Printf("abuseipdb reporting enabled: dailyQuota=%d queueSize=%d", cfg.ReportConfig.DailyQuota, cfg.ReportConfig.QueueSize)
 	}
-	apis := ipqapi.Server{LookupClient: lc}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
+	r.Use(ipqapi.ProxyProtocolResolver)
+	r.Use(ipqapi.ClientIPResolver(lc))
 	r.Use(ipqapi.AccessLogger(apis.GetClientIP))
 
+	suspicious := ipqapi.NewCounterVec("ipquery_client_ip_suspicious_total",
+		"Requests whose client address looked spoofed or misconfigured, by reason.", "reason")
+	r.Use(ipqapi.ClientIPAuditor(lc, suspicious))
+	if apis.Sightings != nil {
+		r.Use(ipqapi.SightingsRecorder(apis.Sightings, apis.GetClientIP))
+	}
+
 	r.Get("/", apis.Index())
 
 	r.Get("/own", apis.GetOwnIP)
 	r.Get("/own/all", apis.GetOwnIPAll)
+	r.Get("/own/debug", apis.GetOwnDebug)
+	r.Get("/own/v4", apis.OwnFamily(false, false))
+	r.Get("/own/v4/all", apis.OwnFamily(false, true))
+	r.Get("/own/v6", apis.OwnFamily(true, false))
+	r.Get("/own/v6/all", apis.OwnFamily(true, true))
 	r.Get("/lookup/{ip}", apis.LookupIPAll)
 	r.Get("/health", apis.GetHealth)
+	r.Get("/metrics", ipqapi.MetricsHandler(suspicious))
+
+	if apis.BlockChecker != nil {
+		r.With(ipqapi.ApiKeyAuth(cfg.AbuseIpDbConfig.BlockApiKeys)).Get("/risk/block/*", apis.GetBlockRisk)
+	}
+
+	if apis.Sightings != nil {
+		r.With(ipqapi.ApiKeyAuth(apis.SightingsApiKeys)).Get("/sightings/{ip}", apis.GetSightings)
+	}
+
+	if cfg.ForwardAuth {
+		r.HandleFunc("/forward-auth", apis.ForwardAuth)
+	}
+
+	if apis.Policy != nil {
+		r.Get("/decide/{ip}", apis.GetDecision)
+		r.Post("/decide", apis.PostDecision)
+	}
+
+	if apis.Reporter != nil {
+		r.With(ipqapi.ApiKeyAuth(cfg.ReportConfig.ApiKeys)).Post("/report", apis.PostReport)
+	}
+
+	if apis.Lists != nil {
+		r.Route("/lists", func(r chi.Router) {
+			r.Use(ipqapi.ApiKeyAuth(cfg.ListsApiKeys))
+			r.Get("/", apis.GetLists)
+			r.Put("/{name}", apis.PutList)
+			r.Delete("/{name}", apis.DeleteList)
+			r.Get("/{name}/entries", apis.GetListEntries)
+			r.Post("/{name}/entries", apis.PostListEntry)
+			r.Delete("/{name}/entries/*", apis.DeleteListEntry)
+		})
+	}
+
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: r, ConnContext: ipqapi.ProxyProtocolConnContext}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	serve := func(fn func() error) {
+		go func() {
+			if err := fn(); !errors.Is(err, http.ErrServerClosed) {
+				select {
+				case serveErr <- err:
+				default:
+				}
+			}
+		}()
+	}
+
+	if cfg.ProxyProtocolAddr != "" {
+		ppTrusted, err := parseCIDRs(cfg.ProxyProtocolCIDRs)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_PROTOCOL_CIDRS: %v", err)
+		}
+		if len(ppTrusted) == 0 {
+			return fmt.Errorf("PROXY_PROTOCOL_CIDRS is required with PROXY_PROTOCOL_LISTEN_ADDR")
+		}
+		if cfg.ProxyProtocolTimeout <= 0 {
+			return fmt.Errorf("invalid PROXY_PROTOCOL_HEADER_TIMEOUT: must be positive")
+		}
+
+		ln, err := net.Listen("tcp", cfg.ProxyProtocolAddr)
+		if err != nil {
+			return fmt.Errorf("proxy protocol listener: %v", err)
+		}
+		ppLn := &ipqapi.ProxyProtocolListener{
+			Listener: ln,
+			Trusted:  ppTrusted,
+			Timeout:  cfg.ProxyProtocolTimeout,
+			Optional: cfg.ProxyProtocolOptional,
+		}
+
+		log.Printf("listening on %s (proxy protocol from %v)", cfg.ProxyProtocolAddr, ppTrusted)
+		serve(func() error { return srv.Serve(ppLn) })
+	}
+
+	// Single-family listeners, for clients to fall back to the other family
+	// when connecting to a dual-stack hostname.
+	for _, l := range []struct{ network, addr string }{{"tcp4", cfg.OwnV4ListenAddr}, {"tcp6", cfg.OwnV6ListenAddr}} {
+		if l.addr == "" {
+			continue
+		}
+		ln, err := net.Listen(l.network, l.addr)
+		if err != nil {
+			return fmt.Errorf("%s listener: %v", l.network, err)
+		}
+
+		log.Printf("listening on %s (%s only)", l.addr, l.network)
+		serve(func() error { return srv.Serve(ln) })
+	}
 
 	log.Printf("listening on %s", cfg.ListenAddr)
-	log.Fatal(http.ListenAndServe(cfg.ListenAddr, r))
+	serve(srv.ListenAndServe)
+
+	var failed error
+	select {
+	case failed = <-serveErr:
+	case <-ctx.Done():
+		log.Print("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	return failed
 }
 
 func parseCIDRs(items []string) ([]*net.IPNet, error) {
@@ -86,3 +624,169 @@ func parseCIDRs(items []string) ([]*net.IPNet, error) {
 	}
 	return out, nil
 }
+
+func parseProxyHeaders(items []string) ([]ipqapi.ProxyHeader, error) {
+	var out []ipqapi.ProxyHeader
+	for _, raw := range items {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		p, err := ipqapi.ParseProxyHeader(raw)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// parsePresetSources parses "<preset>=<file or url>" items.
+func parsePresetSources(items []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, raw := range items {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		name, src, ok := strings.Cut(strings.TrimSpace(raw), "=")
+		if !ok || strings.TrimSpace(src) == "" {
+			return nil, fmt.Errorf("bad preset source %q, expected <preset>=<file or url>", raw)
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(src)
+	}
+	return out, nil
+}
+
+func parseFeedSpecs(items []string) ([]ipqapi.FeedSpec, error) {
+	var out []ipqapi.FeedSpec
+	seen := map[string]bool{}
+	for _, raw := range items {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		spec, err := ipqapi.ParseFeedSpec(raw)
+		if err != nil {
+			return nil, err
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("duplicate feed name %q", spec.Name)
+		}
+		seen[spec.Name] = true
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+func parseHostingSpecs(items []string) ([]ipqapi.HostingSpec, error) {
+	var out []ipqapi.HostingSpec
+	for _, raw := range items {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		spec, err := ipqapi.ParseHostingSpec(raw)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+func validateRiskWeights(w ipqapi.RiskWeights) error {
+	for name, v := range map[string]float64{
+		"RISK_WEIGHT_ABUSE":         w.Abuse,
+		"RISK_WEIGHT_TOR":           w.Tor,
+		"RISK_WEIGHT_HOSTING":       w.Hosting,
+		"RISK_WEIGHT_BLOCKLIST_HIT": w.BlocklistHit,
+		"RISK_WEIGHT_BLOCKLIST_MAX": w.BlocklistMax,
+		"RISK_WEIGHT_ANONYMOUS":     w.Anonymous,
+		"RISK_WEIGHT_RECENCY":       w.Recency,
+		"RISK_WEIGHT_REPUTATION":    w.Reputation,
+	} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s %g: must be between 0 and 100", name, v)
+		}
+	}
+	if w.RecencyWindow < 0 {
+		return fmt.Errorf("RISK_RECENCY_WINDOW %s: must not be negative", w.RecencyWindow)
+	}
+	return nil
+}
+
+// validateOwnUrl checks an optional base url of the family-specific own
+// endpoints.
+func validateOwnUrl(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q: must be an absolute http(s) url", raw)
+	}
+	return nil
+}
+
+func validateAbuseIpDbConfig(cfg AbuseIpDbConfig, includeReports bool) error {
+	u, err := url.Parse(cfg.BaseUrl)
+	if err != nil {
+		return fmt.Errorf("ABUSEIPDB_BASE_URL %q: %w", cfg.BaseUrl, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("ABUSEIPDB_BASE_URL %q: must be an absolute http(s) url", cfg.BaseUrl)
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("ABUSEIPDB_TIMEOUT %s: must be positive", cfg.Timeout)
+	}
+	if cfg.MaxAgeInDays < 1 || cfg.MaxAgeInDays > ipqapi.AbuseIpDbMaxAgeInDaysLimit {
+		return fmt.Errorf("ABUSEIPDB_MAX_AGE_DAYS %d: must be between 1 and %d", cfg.MaxAgeInDays, ipqapi.AbuseIpDbMaxAgeInDaysLimit)
+	}
+	if cfg.MinConfidence < 0 || cfg.MinConfidence > 100 {
+		return fmt.Errorf("ABUSEIPDB_MIN_CONFIDENCE %d: must be between 0 and 100", cfg.MinConfidence)
+	}
+	if cfg.BlockMinV4 < 1 || cfg.BlockMinV4 > 32 {
+		return fmt.Errorf("ABUSEIPDB_BLOCK_MIN_PREFIX_V4 %d: must be between 1 and 32", cfg.BlockMinV4)
+	}
+	if cfg.BlockMinV6 < 1 || cfg.BlockMinV6 > 128 {
+		return fmt.Errorf("ABUSEIPDB_BLOCK_MIN_PREFIX_V6 %d: must be between 1 and 128", cfg.BlockMinV6)
+	}
+	if cfg.BlockCacheTTL < 0 {
+		return fmt.Errorf("ABUSEIPDB_BLOCK_CACHE_TTL %s: must not be negative", cfg.BlockCacheTTL)
+	}
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("ABUSEIPDB_CACHE_TTL %s: must not be negative", cfg.CacheTTL)
+	}
+	if includeReports && !cfg.Verbose {
+		return fmt.Errorf("ABUSEIPDB_REPORTS requires ABUSEIPDB_VERBOSE=true")
+	}
+	return nil
+}
+
+func validateReputationConfig(prefix string, needsKey bool, cfg ReputationConfig) error {
+	if !cfg.Enabled && cfg.ApiKey == "" {
+		return nil
+	}
+	if needsKey && cfg.ApiKey == "" {
+		return fmt.Errorf("%sAPI_KEY is required", prefix)
+	}
+	if cfg.BaseUrl != "" {
+		u, err := url.Parse(cfg.BaseUrl)
+		if err != nil {
+			return fmt.Errorf("%sBASE_URL %q: %w", prefix, cfg.BaseUrl, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%sBASE_URL %q: must be an absolute http(s) url", prefix, cfg.BaseUrl)
+		}
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("%sTIMEOUT %s: must be positive", prefix, cfg.Timeout)
+	}
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("%sCACHE_TTL %s: must not be negative", prefix, cfg.CacheTTL)
+	}
+	if cfg.DailyQuota < 0 {
+		return fmt.Errorf("%sDAILY_QUOTA %d: must not be negative", prefix, cfg.DailyQuota)
+	}
+	return nil
+}